@@ -87,15 +87,30 @@ func (cnf *AppConfig) enabledMessageSplitting() bool {
 }
 
 type RouteConfig struct {
-	Name      string     `yaml:"name"`
-	Condition *Condition `yaml:"condition"`
+	Name      string             `yaml:"name"`
+	Condition *Condition         `yaml:"condition"`
+	Explode   string             `yaml:"explode"`  // gjson path to an array, each element is delivered as its own message
+	Lambda    *LambdaRouteConfig `yaml:"lambda"`   // lambda target: function of messages of this route
+	Exchange  string             `yaml:"exchange"` // rabbitmq target: exchange of messages of this route
 }
 
 type TargetConfig struct {
-	Type     string                `yaml:"type"`
-	RabbitMq *RabbitMqTargetConfig `yaml:"rabbitmq"`
-	Kafka    *KafkaServiceConfig   `yaml:"kafka"`
-	Process  ProcessTargetConfig   `yaml:"process"`
+	Type          string                     `yaml:"type"`
+	RabbitMq      *RabbitMqTargetConfig      `yaml:"rabbitmq"`
+	Http          *HttpTargetConfig          `yaml:"http"`
+	Grpc          *GrpcTargetConfig          `yaml:"grpc"`
+	Redis         *RedisTargetConfig         `yaml:"redis"`
+	Sqs           *SqsTargetConfig           `yaml:"sqs"`
+	Sns           *SnsTargetConfig           `yaml:"sns"`
+	File          *FileTargetConfig          `yaml:"file"`
+	Kafka         *KafkaServiceConfig        `yaml:"kafka"`
+	Process       ProcessTargetConfig        `yaml:"process"`
+	Sql           *SqlTargetConfig           `yaml:"sql"`
+	Elasticsearch *ElasticsearchTargetConfig `yaml:"elasticsearch"`
+	Nats          *NatsTargetConfig          `yaml:"nats"`
+	Mqtt          *MqttTargetConfig          `yaml:"mqtt"`
+	Encoding      *EncodingConfig            `yaml:"encoding"`
+	Encode        *SerializerConfig          `yaml:"encode"`
 }
 
 type Conditions []Condition
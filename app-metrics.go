@@ -45,6 +45,14 @@ var promFilteredMessageCounter = prometheus.NewCounterVec(
 	[]string{"queue", "service", "routing_key"},
 )
 
+var promRejectedMessageCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "consumer_total_rejected_message",
+		Help: "The number of message rejected by the target, dropped or dead-lettered",
+	},
+	[]string{"queue", "service", "routing_key", "action"},
+)
+
 var promDurationHistogram = prometheus.NewHistogramVec(
 	prometheus.HistogramOpts{
 		Name:    "consumer_consume_duration_seconds",
@@ -54,12 +62,33 @@ var promDurationHistogram = prometheus.NewHistogramVec(
 	[]string{"queue", "service", "routing_key"},
 )
 
+var promHttpResponseCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "consumer_total_http_response",
+		Help: "The number of HTTP target responses by outcome and status class",
+	},
+	[]string{"service", "outcome", "status_class"},
+)
+
+func observeResponse(service string, statusCode int, err error) {
+	outcome := ActionAck
+	if targetErr, ok := err.(*TargetError); ok {
+		outcome = targetErr.Action
+	}
+
+	promHttpResponseCounter.
+		WithLabelValues(service, outcome, statusClass(statusCode)).
+		Inc()
+}
+
 func startPrometheusServer(ctx context.Context, addr string) {
 	prometheus.MustRegister(promDurationHistogram)
 	prometheus.MustRegister(promSuccessMessageCounter)
 	prometheus.MustRegister(promFailureMessageCounter)
 	prometheus.MustRegister(promRetryMessageCounter)
 	prometheus.MustRegister(promFilteredMessageCounter)
+	prometheus.MustRegister(promRejectedMessageCounter)
+	prometheus.MustRegister(promHttpResponseCounter)
 
 	logrus.
 		WithField("add", addr).
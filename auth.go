@@ -0,0 +1,274 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Secret value, read from environment variable or file so that it never lives in config or binary.
+type Secret struct {
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+func (s *Secret) value() (string, error) {
+	if nil == s {
+		return "", nil
+	}
+
+	if "" != s.Env {
+		value := os.Getenv(s.Env)
+		if "" == value {
+			return "", fmt.Errorf("environment variable %s is empty", s.Env)
+		}
+
+		return value, nil
+	}
+
+	if "" != s.File {
+		value, err := ioutil.ReadFile(s.File)
+		if nil != err {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(value)), nil
+	}
+
+	return "", nil
+}
+
+type AuthConfig struct {
+	Type string `yaml:"type"` // none (default), bearer, basic, hmac, oauth2, aws-sigv4
+
+	// bearer
+	Token *Secret `yaml:"token"`
+
+	// basic
+	Username string  `yaml:"username"`
+	Password *Secret `yaml:"password"`
+
+	// hmac: signature of request body
+	Secret    *Secret `yaml:"secret"`
+	Header    string  `yaml:"header"`    // Default: X-Signature
+	Algorithm string  `yaml:"algorithm"` // sha1, sha256 (default), sha512
+	Prefix    string  `yaml:"prefix"`    // ex: "sha256="
+
+	// oauth2 client-credentials
+	TokenUrl     string   `yaml:"token-url"`
+	ClientId     string   `yaml:"client-id"`
+	ClientSecret *Secret  `yaml:"client-secret"`
+	Scopes       []string `yaml:"scopes"`
+
+	// aws-sigv4, credentials are resolved by the default AWS credential chain
+	Region  string `yaml:"region"`
+	Service string `yaml:"service"` // Default: execute-api
+
+	mu            sync.Mutex
+	authenticator Authenticator
+}
+
+type Authenticator interface {
+	authenticate(req *http.Request, body []byte) error
+}
+
+// Authenticator is shared between workers, so that OAuth2 tokens are cached once.
+func (c *AuthConfig) get() (Authenticator, error) {
+	if nil == c {
+		return noAuth{}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nil == c.authenticator {
+		authenticator, err := NewAuthenticator(c)
+		if nil != err {
+			return nil, err
+		}
+
+		c.authenticator = authenticator
+	}
+
+	return c.authenticator, nil
+}
+
+// Fallback of deployments relying on the removed hard-coded JWT, the token is read from ROOT_JWT until auth is configured.
+func legacyAuth(component string) *AuthConfig {
+	if "" == os.Getenv("ROOT_JWT") {
+		return nil
+	}
+
+	logrus.
+		WithField("component", component).
+		Warning("ROOT_JWT is deprecated, configure a bearer auth instead")
+
+	return &AuthConfig{Type: "bearer", Token: &Secret{Env: "ROOT_JWT"}}
+}
+
+func NewAuthenticator(cnf *AuthConfig) (Authenticator, error) {
+	switch cnf.Type {
+	case "", "none":
+		return noAuth{}, nil
+
+	case "bearer":
+		token, err := cnf.Token.value()
+		if nil != err {
+			return nil, err
+		}
+
+		return &bearerAuth{token: token}, nil
+
+	case "basic":
+		password, err := cnf.Password.value()
+		if nil != err {
+			return nil, err
+		}
+
+		return &basicAuth{username: cnf.Username, password: password}, nil
+
+	case "hmac":
+		secret, err := cnf.Secret.value()
+		if nil != err {
+			return nil, err
+		}
+
+		a := &hmacAuth{secret: []byte(secret), header: cnf.Header, prefix: cnf.Prefix}
+		if "" == a.header {
+			a.header = "X-Signature"
+		}
+
+		switch cnf.Algorithm {
+		case "sha1":
+			a.hash = sha1.New
+		case "", "sha256":
+			a.hash = sha256.New
+		case "sha512":
+			a.hash = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported hmac algorithm: %s", cnf.Algorithm)
+		}
+
+		return a, nil
+
+	case "oauth2":
+		secret, err := cnf.ClientSecret.value()
+		if nil != err {
+			return nil, err
+		}
+
+		credentials := clientcredentials.Config{
+			ClientID:     cnf.ClientId,
+			ClientSecret: secret,
+			TokenURL:     cnf.TokenUrl,
+			Scopes:       cnf.Scopes,
+		}
+
+		return &oauth2Auth{tokens: credentials.TokenSource(context.Background())}, nil
+
+	case "aws-sigv4":
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if nil != err {
+			return nil, err
+		}
+
+		a := &sigV4Auth{signer: v4.NewSigner(sess.Config.Credentials), region: cnf.Region, service: cnf.Service}
+		if "" == a.service {
+			a.service = "execute-api"
+		}
+
+		if "" == a.region && nil != sess.Config.Region {
+			a.region = *sess.Config.Region
+		}
+
+		return a, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported auth type: %s", cnf.Type)
+	}
+}
+
+type noAuth struct{}
+
+func (noAuth) authenticate(req *http.Request, body []byte) error { return nil }
+
+type bearerAuth struct {
+	token string
+}
+
+func (a *bearerAuth) authenticate(req *http.Request, body []byte) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	return nil
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) authenticate(req *http.Request, body []byte) error {
+	req.SetBasicAuth(a.username, a.password)
+
+	return nil
+}
+
+type hmacAuth struct {
+	secret []byte
+	header string
+	prefix string
+	hash   func() hash.Hash
+}
+
+func (a *hmacAuth) authenticate(req *http.Request, body []byte) error {
+	mac := hmac.New(a.hash, a.secret)
+	mac.Write(body)
+	req.Header.Set(a.header, a.prefix+hex.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
+
+type oauth2Auth struct {
+	tokens oauth2.TokenSource // caches the token until it expires
+}
+
+func (a *oauth2Auth) authenticate(req *http.Request, body []byte) error {
+	token, err := a.tokens.Token()
+	if nil != err {
+		return err
+	}
+
+	token.SetAuthHeader(req)
+
+	return nil
+}
+
+type sigV4Auth struct {
+	signer  *v4.Signer
+	region  string
+	service string
+}
+
+func (a *sigV4Auth) authenticate(req *http.Request, body []byte) error {
+	_, err := a.signer.Sign(req, bytes.NewReader(body), a.service, a.region, time.Now())
+
+	return err
+}
@@ -0,0 +1,178 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func authenticate(t *testing.T, cnf *AuthConfig, body string) *http.Request {
+	auth, err := NewAuthenticator(cnf)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://webhook.qa.go1.service/events", strings.NewReader(body))
+	if err := auth.authenticate(req, []byte(body)); nil != err {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestBearerAuth(t *testing.T) {
+	ass := assert.New(t)
+
+	t.Setenv("CONSUMER_JWT", "jwt")
+	req := authenticate(t, &AuthConfig{Type: "bearer", Token: &Secret{Env: "CONSUMER_JWT"}}, "")
+	ass.Equal("Bearer jwt", req.Header.Get("Authorization"))
+
+	t.Setenv("CONSUMER_JWT", "")
+	_, err := NewAuthenticator(&AuthConfig{Type: "bearer", Token: &Secret{Env: "CONSUMER_JWT"}})
+	ass.Error(err, "empty secrets are rejected")
+}
+
+func TestBasicAuth(t *testing.T) {
+	ass := assert.New(t)
+	file := filepath.Join(t.TempDir(), "password")
+	ass.NoError(ioutil.WriteFile(file, []byte("secret\n"), 0600))
+
+	req := authenticate(t, &AuthConfig{Type: "basic", Username: "consumer", Password: &Secret{File: file}}, "")
+	username, password, ok := req.BasicAuth()
+	ass.True(ok)
+	ass.Equal("consumer", username)
+	ass.Equal("secret", password, "secret files are trimmed")
+}
+
+func TestHmacAuth(t *testing.T) {
+	ass := assert.New(t)
+	body := `{"id": 1}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	t.Setenv("WEBHOOK_SECRET", "secret")
+	req := authenticate(t, &AuthConfig{Type: "hmac", Secret: &Secret{Env: "WEBHOOK_SECRET"}}, body)
+	ass.Equal(signature, req.Header.Get("X-Signature"))
+
+	req = authenticate(t, &AuthConfig{
+		Type:      "hmac",
+		Secret:    &Secret{Env: "WEBHOOK_SECRET"},
+		Header:    "X-Hub-Signature-256",
+		Algorithm: "sha256",
+		Prefix:    "sha256=",
+	}, body)
+	ass.Equal("sha256="+signature, req.Header.Get("X-Hub-Signature-256"))
+
+	_, err := NewAuthenticator(&AuthConfig{Type: "hmac", Secret: &Secret{Env: "WEBHOOK_SECRET"}, Algorithm: "md5"})
+	ass.Error(err)
+}
+
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	issued := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if "client_credentials" != r.PostForm.Get("grant_type") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, token, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &issued
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	ass := assert.New(t)
+	server, issued := newTokenServer(t, 3600)
+
+	t.Setenv("OAUTH_CLIENT_SECRET", "secret")
+	cnf := &AuthConfig{Type: "oauth2", TokenUrl: server.URL, ClientId: "consumer", ClientSecret: &Secret{Env: "OAUTH_CLIENT_SECRET"}}
+	auth, err := cnf.get()
+	ass.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "https://webhook.qa.go1.service/events", nil)
+		ass.NoError(auth.authenticate(req, nil))
+		ass.Equal("Bearer token-1", req.Header.Get("Authorization"))
+	}
+
+	shared, _ := cnf.get()
+	ass.Same(auth, shared, "authenticator is shared between workers")
+	ass.Equal(int32(1), atomic.LoadInt32(issued), "token is cached until it expires")
+}
+
+func TestOAuth2AuthRefresh(t *testing.T) {
+	ass := assert.New(t)
+
+	// tokens expiring within the expiry delta of the oauth2 package are refreshed on every use
+	server, issued := newTokenServer(t, 1)
+
+	t.Setenv("OAUTH_CLIENT_SECRET", "secret")
+	cnf := &AuthConfig{Type: "oauth2", TokenUrl: server.URL, ClientId: "consumer", ClientSecret: &Secret{Env: "OAUTH_CLIENT_SECRET"}}
+	ass.Equal("Bearer token-1", authenticate(t, cnf, "").Header.Get("Authorization"))
+
+	auth, _ := NewAuthenticator(cnf)
+	for _, token := range []string{"token-2", "token-3"} {
+		req, _ := http.NewRequest(http.MethodPost, "https://webhook.qa.go1.service/events", nil)
+		ass.NoError(auth.authenticate(req, nil))
+		ass.Equal("Bearer "+token, req.Header.Get("Authorization"))
+	}
+
+	ass.Equal(int32(3), atomic.LoadInt32(issued))
+}
+
+func TestSigV4Auth(t *testing.T) {
+	ass := assert.New(t)
+
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "ap-southeast-2")
+
+	req := authenticate(t, &AuthConfig{Type: "aws-sigv4"}, `{"id": 1}`)
+	authorization := req.Header.Get("Authorization")
+	ass.True(strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+	ass.Contains(authorization, "/ap-southeast-2/execute-api/aws4_request")
+	ass.NotEmpty(req.Header.Get("X-Amz-Date"))
+
+	req = authenticate(t, &AuthConfig{Type: "aws-sigv4", Region: "us-east-1", Service: "lambda"}, `{"id": 1}`)
+	ass.Contains(req.Header.Get("Authorization"), "/us-east-1/lambda/aws4_request")
+}
+
+func TestLegacyAuth(t *testing.T) {
+	ass := assert.New(t)
+
+	t.Setenv("ROOT_JWT", "")
+	cnf, err := NewAppConfig([]byte(`retry-intervals: ["1ms"]`))
+	ass.NoError(err)
+	ass.Nil(cnf.HttpClient.Auth)
+
+	t.Setenv("ROOT_JWT", "jwt")
+	cnf, err = NewAppConfig([]byte(`retry-intervals: ["1ms"]`))
+	ass.NoError(err)
+	ass.Equal("Bearer jwt", authenticate(t, cnf.HttpClient.Auth, "").Header.Get("Authorization"))
+
+	t.Setenv("CONSUMER_JWT", "consumer")
+	cnf, err = NewAppConfig([]byte(`
+http-client:
+  auth: { type: "bearer", token: { env: "CONSUMER_JWT" } }
+`))
+	ass.NoError(err)
+	ass.Equal("Bearer consumer", authenticate(t, cnf.HttpClient.Auth, "").Header.Get("Authorization"), "configured auth wins")
+}
@@ -0,0 +1,167 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/tidwall/gjson"
+)
+
+// Connection options shared by AWS targets: lambda, sqs, sns.
+type AwsConfig struct {
+	AuthKey    string               `yaml:"auth-key"`    // static credentials, default: AWS credential chain (env, shared profile, web identity, instance role)
+	AuthSecret string               `yaml:"auth-secret"` // prefer ${ENV_VAR} to committing secrets
+	Profile    string               `yaml:"profile"`     // shared config profile
+	Region     string               `yaml:"region"`
+	Endpoint   string               `yaml:"endpoint"` // custom endpoint URL, ex: local stand-in for testing
+	AssumeRole *AwsAssumeRoleConfig `yaml:"assume-role"`
+}
+
+type AwsAssumeRoleConfig struct {
+	RoleArn     string        `yaml:"role-arn"`
+	ExternalId  string        `yaml:"external-id"`
+	SessionName string        `yaml:"session-name"` // Default: rabbitmq-consumer-bridge
+	Duration    time.Duration `yaml:"duration"`     // Default: 15m
+}
+
+func (c *AwsConfig) session() (*session.Session, error) {
+	awsConfig := aws.NewConfig()
+	if "" != c.Region {
+		awsConfig = awsConfig.WithRegion(c.Region)
+	}
+
+	if "" != c.AuthKey {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(c.AuthKey, c.AuthSecret, ""))
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		Profile:           c.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+
+	if nil != err {
+		return nil, err
+	}
+
+	if nil != c.AssumeRole {
+		sess.Config.Credentials = stscreds.NewCredentials(sess, c.AssumeRole.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = c.AssumeRole.SessionName
+			if "" == p.RoleSessionName {
+				p.RoleSessionName = "rabbitmq-consumer-bridge"
+			}
+
+			if "" != c.AssumeRole.ExternalId {
+				p.ExternalID = aws.String(c.AssumeRole.ExternalId)
+			}
+
+			if 0 != c.AssumeRole.Duration {
+				p.Duration = c.AssumeRole.Duration
+			}
+		})
+	}
+
+	return sess, nil
+}
+
+// Config of service clients, the endpoint is not applied to the session so that STS is still reached.
+func (c *AwsConfig) config() *aws.Config {
+	awsConfig := aws.NewConfig()
+	if "" != c.Endpoint {
+		awsConfig = awsConfig.WithEndpoint(c.Endpoint)
+	}
+
+	return awsConfig
+}
+
+// Max number of message attributes accepted by SQS & SNS.
+const awsMaxMessageAttributes = 10
+
+var awsAttributeName = regexp.MustCompile(`^[a-zA-Z0-9_\-.]{1,256}$`)
+
+type awsAttribute struct {
+	dataType    string // String, Number, Binary
+	stringValue string
+	binaryValue []byte
+}
+
+// Convert message headers to SQS/SNS message attributes.
+func awsAttributes(headers amqp.Table) map[string]awsAttribute {
+	keys := []string{}
+	for key := range headers {
+		lower := strings.ToLower(key)
+		if !awsAttributeName.MatchString(key) || strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) > awsMaxMessageAttributes {
+		logrus.
+			WithField("component", "aws").
+			WithField("dropped", keys[awsMaxMessageAttributes:]).
+			Warning("too many headers for message attributes")
+
+		keys = keys[:awsMaxMessageAttributes]
+	}
+
+	attributes := map[string]awsAttribute{}
+	for _, key := range keys {
+		switch value := headers[key].(type) {
+		case string:
+			if "" != value {
+				attributes[key] = awsAttribute{dataType: "String", stringValue: value}
+			}
+
+		case []byte:
+			attributes[key] = awsAttribute{dataType: "Binary", binaryValue: value}
+
+		case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64, float32, float64:
+			attributes[key] = awsAttribute{dataType: "Number", stringValue: fmt.Sprint(value)}
+
+		case bool:
+			attributes[key] = awsAttribute{dataType: "String", stringValue: strconv.FormatBool(value)}
+
+		case nil:
+
+		default:
+			raw, _ := json.Marshal(value)
+			attributes[key] = awsAttribute{dataType: "String", stringValue: string(raw)}
+		}
+	}
+
+	return attributes
+}
+
+// FIFO message group ID from gjson path of body, fallback to the routing key.
+func awsMessageGroupId(path string, m *amqp.Delivery) string {
+	if "" != path {
+		if value := gjson.GetBytes(m.Body, path).String(); "" != value {
+			return value
+		}
+	}
+
+	return m.RoutingKey
+}
+
+// Deduplication ID of FIFO messages, nil to use content-based deduplication.
+func awsDeduplicationId(m *amqp.Delivery) *string {
+	if uuid, ok := m.Headers["X-UUID"].(string); ok && "" != uuid {
+		return aws.String(uuid)
+	}
+
+	return nil
+}
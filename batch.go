@@ -0,0 +1,297 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/streadway/amqp"
+)
+
+type BatchConfig struct {
+	Size      int           `yaml:"size"`       // max number of messages per delivery. Default: 100
+	Interval  time.Duration `yaml:"interval"`   // max time to wait for a batch to be filled. Default: 1s
+	OnFailure string        `yaml:"on-failure"` // batch (default): retry/dead-letter the whole batch, individual: process members one by one
+}
+
+func (c *BatchConfig) onParse() {
+	if c.Size < 1 {
+		c.Size = 100
+	}
+
+	if 0 == c.Interval {
+		c.Interval = time.Second
+	}
+
+	if "" == c.OnFailure {
+		c.OnFailure = "batch"
+	}
+}
+
+// Target which can deliver multiple messages in a single call.
+type BatchTarget interface {
+	handleBatch(ms []*amqp.Delivery) ([]byte, error)
+}
+
+// Error of batch targets which delivered only part of the batch, failures are keyed by index of the member.
+// Targets calling multiple endpoints per batch return it even if no member failed, to report all responses.
+type BatchError struct {
+	Errs      map[int]error
+	Responses [][]byte // of the delivered members, passed to the pipeline
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d members of the batch failed", len(e.Errs))
+}
+
+func handleBatch(target Target, ms []*amqp.Delivery) ([]byte, error) {
+	if t, ok := target.(BatchTarget); ok {
+		return t.handleBatch(ms)
+	}
+
+	for _, m := range ms {
+		if _, err := target.handle(m); nil != err {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+type batcher struct {
+	service *Service
+	ch      acknowledger
+	cnf     *BatchConfig
+	handler func(m *amqp.Delivery) // of messages which are not batched
+
+	mu    sync.Mutex // guards the pending items, never held while delivering
+	items []*amqp.Delivery
+	timer *time.Timer
+
+	deliveryMu sync.Mutex // batches are flushed by the consumer & the timer, the target handles one at a time
+}
+
+func newBatcher(service *Service, ch acknowledger) *batcher {
+	return &batcher{
+		service: service,
+		ch:      ch,
+		cnf:     service.cnf.Batch,
+		handler: service.handler(ch),
+		items:   []*amqp.Delivery{},
+	}
+}
+
+func (b *batcher) add(m *amqp.Delivery) {
+	// exploded messages are delivered one by one
+	if "" != b.service.cnf.explodePath(m.RoutingKey) {
+		b.deliveryMu.Lock()
+		defer b.deliveryMu.Unlock()
+
+		b.handler(m)
+		return
+	}
+
+	item := *m
+	b.service.prepare(&item)
+
+	b.mu.Lock()
+	b.items = append(b.items, &item)
+	if 1 == len(b.items) {
+		b.timer = time.AfterFunc(b.cnf.Interval, b.flush)
+	}
+
+	var items []*amqp.Delivery
+	if len(b.items) >= b.cnf.Size {
+		items = b.take()
+	}
+	b.mu.Unlock()
+
+	if 0 != len(items) {
+		b.deliver(items)
+	}
+}
+
+func (b *batcher) flush() {
+	b.mu.Lock()
+	items := b.take()
+	b.mu.Unlock()
+
+	if 0 != len(items) {
+		b.deliver(items)
+	}
+}
+
+// Caller must hold the lock.
+func (b *batcher) take() []*amqp.Delivery {
+	if nil != b.timer {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	items := b.items
+	b.items = []*amqp.Delivery{}
+
+	return items
+}
+
+func (b *batcher) deliver(items []*amqp.Delivery) {
+	b.deliveryMu.Lock()
+	defer b.deliveryMu.Unlock()
+
+	c := b.service
+
+	defer func() {
+		if err := recover(); nil != err {
+			c.log(nil).
+				WithField("batch.size", len(items)).
+				WithField("error.trace", errors.New(err).ErrorStack()).
+				Errorf("recovered from panic: %s", err)
+		}
+	}()
+
+	// attempts are counted per member, those which can't be dead-lettered are delivered again
+	if nil != c.cnf.DeadLetter {
+		remaining := []*amqp.Delivery{}
+		for _, m := range items {
+			if !c.deadLetter(b.ch, m) {
+				remaining = append(remaining, m)
+			}
+		}
+
+		if 0 == len(remaining) {
+			return
+		}
+
+		items = remaining
+	}
+
+	start := time.Now()
+	response, err := handleBatch(c.target, items)
+	if nil == err && nil != response && nil != c.pipeline && !c.pipeline.invoke(response) {
+		err = errors.New("failed execute the pipeline")
+	}
+
+	if nil == err {
+		for _, m := range items {
+			b.ch.Ack(m.DeliveryTag, false)
+			c.forget(m)
+			promDurationHistogram.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Observe(time.Since(start).Seconds())
+			promSuccessMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+		}
+
+		return
+	}
+
+	if batchErr, ok := err.(*BatchError); ok {
+		b.settle(items, batchErr, start)
+		return
+	}
+
+	var retryAfter time.Duration
+	if targetErr, ok := err.(*TargetError); ok {
+		if ActionRetry != targetErr.Action {
+			unsettled := []*amqp.Delivery{}
+			for _, m := range items {
+				if !c.settle(b.ch, m, targetErr) {
+					unsettled = append(unsettled, m)
+				}
+			}
+
+			if 0 == len(unsettled) {
+				return
+			}
+
+			items = unsettled
+		}
+
+		retryAfter = targetErr.RetryAfter
+	}
+
+	c.log(err).
+		WithField("batch.size", len(items)).
+		Errorf("failed execute the target")
+
+	for _, m := range items {
+		promFailureMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+	}
+
+	if "individual" == b.cnf.OnFailure {
+		for _, m := range items {
+			b.handler(m)
+		}
+
+		return
+	}
+
+	b.retry(items, retryAfter)
+}
+
+// Ack delivered members of the batch, settle or retry the failed ones.
+func (b *batcher) settle(items []*amqp.Delivery, batchErr *BatchError, start time.Time) {
+	c := b.service
+	failed := []*amqp.Delivery{}
+
+	// delivered members are retried if their responses can't be piped
+	var pipelineErr error
+	for _, response := range batchErr.Responses {
+		if nil != response && nil != c.pipeline && !c.pipeline.invoke(response) {
+			pipelineErr = errors.New("failed execute the pipeline")
+		}
+	}
+
+	var retryAfter time.Duration
+	for i, m := range items {
+		err, ok := batchErr.Errs[i]
+		if !ok && nil != pipelineErr {
+			err, ok = pipelineErr, true
+		}
+
+		if !ok {
+			b.ch.Ack(m.DeliveryTag, false)
+			c.forget(m)
+			promDurationHistogram.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Observe(time.Since(start).Seconds())
+			promSuccessMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+			continue
+		}
+
+		promFailureMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+
+		if targetErr, ok := err.(*TargetError); ok {
+			if ActionRetry != targetErr.Action && c.settle(b.ch, m, targetErr) {
+				continue
+			}
+
+			if targetErr.RetryAfter > retryAfter {
+				retryAfter = targetErr.RetryAfter
+			}
+		}
+
+		c.log(err).
+			WithField("msg.routingKey", m.RoutingKey).
+			Errorf("failed execute the target")
+
+		failed = append(failed, m)
+	}
+
+	if 0 != len(failed) {
+		b.retry(failed, retryAfter)
+	}
+}
+
+// Requeue the messages, next batches wait for the retry interval.
+func (b *batcher) retry(items []*amqp.Delivery, retryAfter time.Duration) {
+	c := b.service
+	retryInterval := c.retryInterval(retryAfter)
+
+	for _, m := range items {
+		promRetryMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+		b.ch.Nack(m.DeliveryTag, false, true)
+	}
+
+	c.log(nil).
+		WithField("batch.size", len(items)).
+		Errorf("failed handling batch, retry in: %s", retryInterval)
+
+	time.Sleep(retryInterval)
+}
@@ -0,0 +1,259 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Channel recording how messages are settled.
+type fakeChannel struct {
+	mu       sync.Mutex
+	acked    []uint64
+	requeued []uint64
+	rejected []uint64
+}
+
+func (ch *fakeChannel) Ack(tag uint64, multiple bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.acked = append(ch.acked, tag)
+
+	return nil
+}
+
+func (ch *fakeChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if requeue {
+		ch.requeued = append(ch.requeued, tag)
+	} else {
+		ch.rejected = append(ch.rejected, tag)
+	}
+
+	return nil
+}
+
+func (ch *fakeChannel) settled() ([]uint64, []uint64, []uint64) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.acked, ch.requeued, ch.rejected
+}
+
+// Batch target failing with the queued errors, one per call.
+type fakeBatchTarget struct {
+	mu      sync.Mutex
+	batches [][]*amqp.Delivery
+	errs    []error
+}
+
+func (t *fakeBatchTarget) start() error     { return nil }
+func (t *fakeBatchTarget) terminate() error { return nil }
+func (t *fakeBatchTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	return t.handleBatch([]*amqp.Delivery{m})
+}
+
+func (t *fakeBatchTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.batches = append(t.batches, ms)
+	if 0 == len(t.errs) {
+		return nil, nil
+	}
+
+	err := t.errs[0]
+	t.errs = t.errs[1:]
+
+	return nil, err
+}
+
+// Retry intervals of the app config are shortened while the test runs.
+func withRetryIntervals(t *testing.T) {
+	app = NewApp(make(chan bool))
+	config := app.config
+	app.config, _ = NewAppConfig([]byte(`retry-intervals: ["1ms"]`))
+	t.Cleanup(func() { app.config = config })
+}
+
+func batchMessage(tag uint64) *amqp.Delivery {
+	return &amqp.Delivery{
+		DeliveryTag: tag,
+		RoutingKey:  "user.create",
+		Body:        []byte(`{"id": 1}`),
+		Headers:     amqp.Table{"X-UUID": fmt.Sprintf("uuid-%d", tag)},
+	}
+}
+
+func newTestBatcher(target Target, batch *BatchConfig, dl *DeadLetter) (*batcher, *fakeChannel) {
+	batch.onParse()
+	ch := &fakeChannel{}
+	c := &Service{
+		cnf:    &ServiceConfig{Name: "batch-service", Queue: "batch-queue", Batch: batch, DeadLetter: dl},
+		target: target,
+	}
+
+	return newBatcher(c, ch), ch
+}
+
+func TestBatchSizeFlush(t *testing.T) {
+	ass := assert.New(t)
+	target := &fakeBatchTarget{}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 2, Interval: time.Hour}, nil)
+
+	b.add(batchMessage(1))
+	b.add(batchMessage(2))
+	b.add(batchMessage(3))
+
+	acked, requeued, rejected := ch.settled()
+	ass.Len(target.batches, 1)
+	ass.Equal([]uint64{1, 2}, acked, "members are acked one by one")
+	ass.Empty(requeued)
+	ass.Empty(rejected)
+	ass.Len(b.items, 1)
+}
+
+func TestBatchIntervalFlush(t *testing.T) {
+	ass := assert.New(t)
+	target := &fakeBatchTarget{}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 10, Interval: 10 * time.Millisecond}, nil)
+
+	b.add(batchMessage(1))
+
+	ass.Eventually(func() bool {
+		acked, _, _ := ch.settled()
+		return 1 == len(acked)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBatchRetry(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	target := &fakeBatchTarget{errs: []error{errors.New("failed")}}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 2, Interval: time.Hour}, nil)
+
+	b.add(batchMessage(1))
+	b.add(batchMessage(2))
+
+	acked, requeued, _ := ch.settled()
+	ass.Empty(acked)
+	ass.Equal([]uint64{1, 2}, requeued)
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	target := &fakeBatchTarget{errs: []error{
+		&BatchError{Errs: map[int]error{
+			1: errors.New("failed"),
+			2: &TargetError{Action: ActionDrop, Err: errors.New("invalid")},
+		}},
+	}}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 3, Interval: time.Hour}, nil)
+
+	b.add(batchMessage(1))
+	b.add(batchMessage(2))
+	b.add(batchMessage(3))
+
+	acked, requeued, rejected := ch.settled()
+	ass.Equal([]uint64{1, 3}, acked)
+	ass.Equal([]uint64{2}, requeued)
+	ass.Empty(rejected)
+}
+
+func TestBatchDeadLetter(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	deadLetters := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadLetters++
+	}))
+	defer server.Close()
+
+	target := &fakeBatchTarget{errs: []error{errors.New("failed")}}
+	dl := &DeadLetter{
+		Condition: DeadLetterCondition{Attempts: 2},
+		Target:    "http",
+		Http:      &DeadLetterHttpTarget{Method: http.MethodPost, Url: server.URL},
+	}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 3, Interval: time.Hour}, dl)
+
+	b.add(batchMessage(1))
+	b.add(batchMessage(2))
+	b.add(batchMessage(3))
+
+	// 2nd attempt of the requeued members, joined by a fresh message
+	for _, tag := range []uint64{1, 2} {
+		m := batchMessage(tag)
+		m.Redelivered = true
+		b.add(m)
+	}
+	b.add(batchMessage(4))
+
+	acked, requeued, rejected := ch.settled()
+	ass.Len(target.batches, 2)
+	ass.Len(target.batches[1], 1, "only the fresh message is delivered")
+	ass.Equal([]uint64{4}, acked)
+	ass.Equal([]uint64{1, 2, 3}, requeued)
+	ass.Equal([]uint64{1, 2}, rejected)
+	ass.Equal(2, deadLetters)
+	ass.Len(b.service.attempts.messages, 1, "attempts of settled messages are forgotten")
+	ass.NotNil(b.service.attempts.messages["uuid-3"])
+}
+
+func TestBatchExplode(t *testing.T) {
+	ass := assert.New(t)
+	target := &fakeBatchTarget{}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 10, Interval: time.Hour}, nil)
+	b.service.cnf.Routes = []RouteConfig{{Name: "user.import", Explode: "users"}}
+
+	m := batchMessage(1)
+	m.RoutingKey = "user.import"
+	m.Body = []byte(`{"users": [{"id": 1}, {"id": 2}]}`)
+	b.add(m)
+
+	acked, _, _ := ch.settled()
+	ass.Len(target.batches, 2, "exploded messages are not batched")
+	ass.Equal([]uint64{1}, acked)
+	ass.Empty(b.items)
+}
+
+type fakePipeline struct {
+	responses []string
+}
+
+func (p *fakePipeline) invoke(response []byte) bool {
+	p.responses = append(p.responses, string(response))
+
+	return true
+}
+
+func TestBatchResponses(t *testing.T) {
+	ass := assert.New(t)
+	pipeline := &fakePipeline{}
+	target := &fakeBatchTarget{errs: []error{
+		&BatchError{Errs: map[int]error{}, Responses: [][]byte{[]byte(`{"id": 1}`), []byte(`{"id": 2}`)}},
+	}}
+	b, ch := newTestBatcher(target, &BatchConfig{Size: 2, Interval: time.Hour}, nil)
+	b.service.pipeline = pipeline
+
+	b.add(batchMessage(1))
+	b.add(batchMessage(2))
+
+	acked, _, _ := ch.settled()
+	ass.Equal([]uint64{1, 2}, acked)
+	ass.Equal([]string{`{"id": 1}`, `{"id": 2}`}, pipeline.responses)
+}
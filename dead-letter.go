@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -19,9 +20,6 @@ type DeadLetter struct {
 	Condition DeadLetterCondition   `yaml:"condition"`
 	Target    string                `yaml:"target"`
 	Http      *DeadLetterHttpTarget `yaml:"http"`
-
-	started  time.Time
-	attempts int
 }
 
 type DeadLetterCondition struct {
@@ -35,32 +33,78 @@ type DeadLetterHttpTarget struct {
 	Body   string `yaml:"body"`
 }
 
-func (dl *DeadLetter) start() {
-	dl.started = time.Now()
-	dl.attempts = 0
+// Time attempts of a message are remembered, messages requeued to other
+// consumers are never redelivered to this one.
+const deadLetterAttemptTtl = time.Hour
+
+// Attempts of the messages in flight, counted per message as batches & async
+// targets have many of them at once. Like explosions, attempts are counted
+// per consumer: a message redelivered to another worker starts counting again.
+type deadLetterAttempts struct {
+	mu       sync.Mutex
+	messages map[string]*deadLetterAttempt
+	swept    time.Time
+}
+
+type deadLetterAttempt struct {
+	started  time.Time
+	attempts int
+	updated  time.Time
 }
 
-func (dl *DeadLetter) HandleFailure(service *ServiceConfig, m *amqp.Delivery) bool {
-	dl.attempts += 1
-	deliver := true
+// Count the attempt of the message, true if the condition to dead-letter it is met.
+// Counting starts again when the message is delivered for the first time.
+func (a *deadLetterAttempts) due(dl *DeadLetter, m *amqp.Delivery) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if nil != dl.Condition.Timeout {
-		if time.Since(dl.started) < *dl.Condition.Timeout {
-			deliver = false
-		}
+	now := time.Now()
+	if nil == a.messages {
+		a.messages = map[string]*deadLetterAttempt{}
+		a.swept = now
 	}
 
-	if dl.Condition.Attempts > 0 {
-		if dl.attempts < dl.Condition.Attempts {
-			deliver = false
+	// forget messages which were not redelivered in time
+	if now.Sub(a.swept) > deadLetterAttemptTtl {
+		for k, attempt := range a.messages {
+			if now.Sub(attempt.updated) > deadLetterAttemptTtl {
+				delete(a.messages, k)
+			}
 		}
+
+		a.swept = now
 	}
 
-	if deliver {
-		return dl.deliver(service, m)
+	key := messageKey(m)
+	attempt, ok := a.messages[key]
+	if !ok || !m.Redelivered {
+		attempt = &deadLetterAttempt{started: now}
+		a.messages[key] = attempt
 	}
 
-	return false
+	attempt.attempts += 1
+	attempt.updated = now
+
+	return dl.Condition.met(attempt.started, attempt.attempts)
+}
+
+func (a *deadLetterAttempts) forget(m *amqp.Delivery) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.messages, messageKey(m))
+}
+
+func (c DeadLetterCondition) met(started time.Time, attempts int) bool {
+	if nil != c.Timeout && time.Since(started) < *c.Timeout {
+		return false
+	}
+
+	if c.Attempts > 0 && attempts < c.Attempts {
+		return false
+	}
+
+	return true
 }
 
 func (dl *DeadLetter) deliver(service *ServiceConfig, m *amqp.Delivery) bool {
@@ -0,0 +1,41 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterAttempts(t *testing.T) {
+	ass := assert.New(t)
+	dl := &DeadLetter{Condition: DeadLetterCondition{Attempts: 3}}
+	a := &deadLetterAttempts{}
+
+	failing, other := batchMessage(1), batchMessage(2)
+	ass.False(a.due(dl, failing))
+	ass.False(a.due(dl, other))
+
+	failing.Redelivered = true
+	ass.False(a.due(dl, failing))
+	ass.False(a.due(dl, batchMessage(3)), "fresh messages don't count for others")
+	ass.True(a.due(dl, failing))
+
+	failing.Redelivered = false
+	ass.False(a.due(dl, failing), "counting starts again on first delivery")
+
+	a.forget(failing)
+	ass.Len(a.messages, 2)
+
+	timeout := time.Minute
+	dl.Condition = DeadLetterCondition{Timeout: &timeout}
+	other.Redelivered = true
+	ass.False(a.due(dl, other))
+	a.messages["uuid-2"].started = time.Now().Add(-2 * time.Minute)
+	ass.True(a.due(dl, other))
+
+	a.messages["uuid-3"].updated = time.Now().Add(-2 * deadLetterAttemptTtl)
+	a.swept = time.Now().Add(-2 * deadLetterAttemptTtl)
+	a.due(dl, other)
+	ass.Nil(a.messages["uuid-3"], "messages which are not redelivered in time are forgotten")
+}
@@ -0,0 +1,135 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	// header keeps the content-encoding the message was published with, body is decompressed.
+	headerOriginalContentEncoding = "X-ORIGINAL-CONTENT-ENCODING"
+)
+
+// Targets applying the outbound encoding, configuring it on other targets is rejected.
+var encodingTargets = map[string]bool{"http": true, "rabbitmq": true, "kafka": true, "nats": true, "mqtt": true}
+
+type EncodingConfig struct {
+	Forward   string `yaml:"forward"`   // plain (default), original
+	Compress  string `yaml:"compress"`  // gzip, deflate
+	Threshold int    `yaml:"threshold"` // only compress when body is larger than N bytes
+}
+
+// Decompress the message body in place, so that conditions & targets work on plain payload.
+func decode(m *amqp.Delivery) error {
+	switch m.ContentEncoding {
+	case EncodingGzip, EncodingDeflate:
+		body, err := decompress(m.ContentEncoding, m.Body)
+		if nil != err {
+			return err
+		}
+
+		if nil == m.Headers {
+			m.Headers = amqp.Table{}
+		}
+
+		m.Headers[headerOriginalContentEncoding] = m.ContentEncoding
+		m.ContentEncoding = ""
+		m.Body = body
+	}
+
+	return nil
+}
+
+// Build the outbound body & its content-encoding.
+func (c *EncodingConfig) encode(m *amqp.Delivery) ([]byte, string, error) {
+	if nil == c {
+		return m.Body, m.ContentEncoding, nil
+	}
+
+	return c.encodeBytes(m.Body, m.Headers)
+}
+
+func (c *EncodingConfig) encodeBytes(body []byte, headers amqp.Table) ([]byte, string, error) {
+	if nil == c {
+		return body, "", nil
+	}
+
+	if "original" == c.Forward {
+		if original, ok := headers[headerOriginalContentEncoding].(string); ok {
+			compressed, err := compress(original, body)
+
+			return compressed, original, err
+		}
+	}
+
+	if "" == c.Compress || len(body) <= c.Threshold {
+		return body, "", nil
+	}
+
+	compressed, err := compress(c.Compress, body)
+	if nil != err {
+		return nil, "", err
+	}
+
+	return compressed, c.Compress, nil
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch encoding {
+	case EncodingGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); nil != err {
+			return nil, err
+		}
+
+		if err := w.Close(); nil != err {
+			return nil, err
+		}
+
+	case EncodingDeflate:
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		if _, err := w.Write(body); nil != err {
+			return nil, err
+		}
+
+		if err := w.Close(); nil != err {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if nil != err {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+
+	case EncodingDeflate:
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+}
@@ -0,0 +1,87 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCompressedBody(t *testing.T) {
+	ass := assert.New(t)
+	plain := []byte(`{"type": "event", "title": "Awesome event somewhere on earth"}`)
+
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		body, err := compress(encoding, plain)
+		ass.NoError(err)
+
+		m := &amqp.Delivery{Body: body, ContentEncoding: encoding, Headers: amqp.Table{}}
+		ass.NoError(decode(m))
+		ass.Equal(plain, m.Body)
+		ass.Equal("", m.ContentEncoding)
+		ass.Equal(encoding, m.Headers[headerOriginalContentEncoding])
+
+		// conditions run against the plain payload
+		f := GJsonFunction{Query: `type`, Part: "body", Operator: "match", Argument: "event"}
+		ass.True(f.validate(m))
+	}
+}
+
+func TestEncodingForward(t *testing.T) {
+	ass := assert.New(t)
+	plain := []byte(`{"id": 1}`)
+	m := &amqp.Delivery{Body: plain, Headers: amqp.Table{headerOriginalContentEncoding: EncodingGzip}}
+
+	// forward plain by default
+	body, contentEncoding, err := (&EncodingConfig{}).encode(m)
+	ass.NoError(err)
+	ass.Equal(plain, body)
+	ass.Equal("", contentEncoding)
+
+	// forward as it was published
+	body, contentEncoding, err = (&EncodingConfig{Forward: "original"}).encode(m)
+	ass.NoError(err)
+	ass.Equal(EncodingGzip, contentEncoding)
+	decompressed, _ := decompress(contentEncoding, body)
+	ass.Equal(plain, decompressed)
+
+	// compress only above threshold
+	c := &EncodingConfig{Compress: EncodingDeflate, Threshold: 100}
+	_, contentEncoding, _ = c.encodeBytes(plain, nil)
+	ass.Equal("", contentEncoding)
+
+	c.Threshold = 5
+	_, contentEncoding, _ = c.encodeBytes(plain, nil)
+	ass.Equal(EncodingDeflate, contentEncoding)
+}
+
+func TestRejectUndecodableBody(t *testing.T) {
+	ass := assert.New(t)
+	terminate := make(chan bool, 1)
+	stream := make(chan amqp.Delivery, 1)
+	stream <- amqp.Delivery{RoutingKey: "lo.update", ContentEncoding: EncodingGzip, Body: []byte("not gzip")}
+
+	handled, rejected := false, false
+	loop(
+		terminate,
+		stream,
+		&ServiceConfig{Name: "lo-index"},
+		func(m *amqp.Delivery) { handled = true },
+		func(uuid string, m amqp.Delivery, failedValidation bool) {
+			rejected = !failedValidation
+			terminate <- true
+		},
+	)
+
+	ass.True(rejected)
+	ass.False(handled)
+}
+
+func TestEncodingUnsupportedTarget(t *testing.T) {
+	ass := assert.New(t)
+
+	for _, kind := range []string{"lambda", "process"} {
+		_, err := newTarget(&ServiceConfig{Name: "lo-index", Target: &TargetConfig{Type: kind, Encoding: &EncodingConfig{Compress: EncodingGzip}}})
+		ass.Error(err)
+	}
+}
@@ -0,0 +1,165 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/streadway/amqp"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	headerExplodeIndex = "X-EXPLODE-INDEX"
+	headerExplodeTotal = "X-EXPLODE-TOTAL"
+)
+
+// Time delivered elements are remembered, source messages requeued to
+// other consumers are never redelivered to this one.
+const explosionTtl = time.Hour
+
+// Keeps the indexes of exploded elements which were delivered, so that
+// a redelivered source message only sends the remaining elements.
+// Deduplication only holds within one consumer: a source message redelivered
+// to another worker or instance sends all of its elements again.
+type explosions struct {
+	mu        sync.Mutex
+	delivered map[string]*explosion
+	swept     time.Time
+}
+
+type explosion struct {
+	indexes map[int]bool
+	updated time.Time
+}
+
+func (e *explosions) done(key string, index int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if x, ok := e.delivered[key]; ok {
+		return x.indexes[index]
+	}
+
+	return false
+}
+
+func (e *explosions) mark(key string, index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if nil == e.delivered {
+		e.delivered = map[string]*explosion{}
+		e.swept = now
+	}
+
+	// forget messages which were not redelivered in time
+	if now.Sub(e.swept) > explosionTtl {
+		for k, x := range e.delivered {
+			if now.Sub(x.updated) > explosionTtl {
+				delete(e.delivered, k)
+			}
+		}
+
+		e.swept = now
+	}
+
+	if nil == e.delivered[key] {
+		e.delivered[key] = &explosion{indexes: map[int]bool{}}
+	}
+
+	e.delivered[key].indexes[index] = true
+	e.delivered[key].updated = now
+}
+
+func (e *explosions) forget(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.delivered, key)
+}
+
+// Identity of a message across redeliveries, messages without X-UUID are told apart by routing key & body.
+func messageKey(m *amqp.Delivery) string {
+	if uuid, ok := m.Headers["X-UUID"].(string); ok && "" != uuid {
+		return uuid
+	}
+
+	hash := sha1.Sum(append([]byte(m.RoutingKey+":"), m.Body...))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *ServiceConfig) explodePath(routingKey string) string {
+	for _, route := range c.Routes {
+		if route.Name == routingKey && "" != route.Explode {
+			return route.Explode
+		}
+	}
+
+	return ""
+}
+
+// Deliver the message to the target, or each element of the array found at
+// the route's `explode` path as its own message. Elements the target drops or
+// dead-letters are settled on their own, the source is acked once all elements are.
+func (c *Service) deliver(m *amqp.Delivery) ([]byte, error) {
+	path := c.cnf.explodePath(m.RoutingKey)
+	if "" == path {
+		return c.target.handle(m)
+	}
+
+	result := gjson.GetBytes(m.Body, path)
+	if !result.IsArray() {
+		return c.target.handle(m)
+	}
+
+	key := messageKey(m)
+	elements := result.Array()
+	for index, element := range elements {
+		if c.explosions.done(key, index) {
+			continue
+		}
+
+		headers := amqp.Table{}
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+
+		headers[headerExplodeIndex] = strconv.Itoa(index)
+		headers[headerExplodeTotal] = strconv.Itoa(len(elements))
+
+		item := *m
+		item.Headers = headers
+		item.Body = []byte(element.Raw)
+
+		response, err := c.target.handle(&item)
+		if targetErr, ok := err.(*TargetError); ok && ActionRetry != targetErr.Action {
+			// elements are settled on their own, the next ones are still delivered
+			if !c.reject(&item, targetErr) {
+				return nil, errors.New("failed dead-lettering the exploded element")
+			}
+
+			c.explosions.mark(key, index)
+			continue
+		}
+
+		if nil != err {
+			return nil, err
+		}
+
+		if nil != response && nil != c.pipeline && !c.pipeline.invoke(response) {
+			return nil, errors.New("failed execute the pipeline")
+		}
+
+		c.explosions.mark(key, index)
+	}
+
+	c.explosions.forget(key)
+
+	return nil, nil
+}
@@ -0,0 +1,136 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyTarget struct {
+	delivered []*amqp.Delivery
+	failAt    int
+}
+
+func (t *flakyTarget) start() error     { return nil }
+func (t *flakyTarget) terminate() error { return nil }
+func (t *flakyTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	if len(t.delivered) == t.failAt {
+		t.failAt = -1
+		return nil, errors.New("failed")
+	}
+
+	t.delivered = append(t.delivered, m)
+
+	return nil, nil
+}
+
+func TestExplodeArrayBody(t *testing.T) {
+	ass := assert.New(t)
+	target := &flakyTarget{failAt: 1}
+	c := &Service{
+		cnf:    &ServiceConfig{Routes: []RouteConfig{{Name: "user.import", Explode: "users"}}},
+		target: target,
+	}
+
+	m := &amqp.Delivery{
+		RoutingKey: "user.import",
+		Body:       []byte(`{"users": [{"id": 1}, {"id": 2}, {"id": 3}]}`),
+		Headers:    amqp.Table{"X-UUID": "abc"},
+	}
+
+	// 2nd element fails, the source message must not be acked.
+	_, err := c.deliver(m)
+	ass.Error(err)
+	ass.Len(target.delivered, 1)
+
+	// on redelivery, only remaining elements are delivered.
+	_, err = c.deliver(m)
+	ass.NoError(err)
+	ass.Len(target.delivered, 3)
+	ass.Equal(`{"id": 3}`, string(target.delivered[2].Body))
+	ass.Equal("2", target.delivered[2].Headers[headerExplodeIndex])
+	ass.Equal("3", target.delivered[2].Headers[headerExplodeTotal])
+	ass.Nil(m.Headers[headerExplodeIndex], "source headers are untouched")
+	ass.Empty(c.explosions.delivered)
+}
+
+func TestExplodeRejectedElements(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	deadLetters := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		deadLetters = append(deadLetters, string(body))
+	}))
+	defer server.Close()
+
+	target := &fakeBatchTarget{errs: []error{
+		&TargetError{Action: ActionDrop, Err: errors.New("invalid")},
+		&TargetError{Action: ActionDeadLetter, Err: errors.New("rejected")},
+	}}
+	ch := &fakeChannel{}
+	c := &Service{
+		cnf: &ServiceConfig{
+			Name:   "explode-service",
+			Queue:  "explode-queue",
+			Routes: []RouteConfig{{Name: "user.import", Explode: "users"}},
+			DeadLetter: &DeadLetter{
+				Condition: DeadLetterCondition{Attempts: 10},
+				Target:    "http",
+				Http:      &DeadLetterHttpTarget{Method: http.MethodPost, Url: server.URL, Body: "%dead-letter%"},
+			},
+		},
+		target: target,
+	}
+
+	m := batchMessage(1)
+	m.RoutingKey = "user.import"
+	m.Body = []byte(`{"users": [{"id": 1}, {"id": 2}, {"id": 3}]}`)
+	c.handler(ch)(m)
+
+	acked, requeued, rejected := ch.settled()
+	ass.Len(target.batches, 3, "elements after the rejected ones are delivered")
+	ass.Equal([]uint64{1}, acked)
+	ass.Empty(requeued)
+	ass.Empty(rejected)
+	ass.Len(deadLetters, 1)
+	ass.Contains(deadLetters[0], `{\"id\": 2}`, "only the rejected element is dead-lettered")
+	ass.Empty(c.explosions.delivered)
+}
+
+func TestExplosionsForgottenOnSettle(t *testing.T) {
+	ass := assert.New(t)
+	c := &Service{cnf: &ServiceConfig{
+		Name:   "explode-service",
+		Queue:  "explode-queue",
+		Routes: []RouteConfig{{Name: "user.import", Explode: "users"}},
+	}}
+
+	m := batchMessage(1)
+	m.RoutingKey = "user.import"
+	c.explosions.mark(messageKey(m), 0)
+
+	ass.True(c.settle(&fakeChannel{}, m, &TargetError{Action: ActionDrop, Err: errors.New("invalid")}))
+	ass.Empty(c.explosions.delivered, "settled source messages are forgotten")
+}
+
+func TestExplosionsExpire(t *testing.T) {
+	ass := assert.New(t)
+	e := &explosions{}
+
+	e.mark("requeued-elsewhere", 0)
+	e.delivered["requeued-elsewhere"].updated = time.Now().Add(-2 * explosionTtl)
+	e.swept = time.Now().Add(-2 * explosionTtl)
+
+	e.mark("redelivered", 0)
+	ass.False(e.done("requeued-elsewhere", 0), "expired elements are forgotten")
+	ass.True(e.done("redelivered", 0))
+	ass.Len(e.delivered, 1)
+}
@@ -19,13 +19,17 @@ type Messages struct {
 
 type PipelineRabbitMq struct {
 	cnf        *RabbitMqTargetConfig
+	encoding   *EncodingConfig
 	Connection *amqp.Connection
 	Channel    *amqp.Channel
+	publisher  *publisher
 }
 
-func NewRabbitMqPipeline(cnf *RabbitMqTargetConfig) (PipeLine, error) {
+func NewRabbitMqPipeline(pipeline *PipelineConfig) (PipeLine, error) {
+	cnf := pipeline.RabbitMq
 	p := PipelineRabbitMq{
-		cnf: cnf,
+		cnf:      cnf,
+		encoding: pipeline.Encoding,
 	}
 
 	conn, err := connection(cnf.URL)
@@ -35,8 +39,9 @@ func NewRabbitMqPipeline(cnf *RabbitMqTargetConfig) (PipeLine, error) {
 
 	p.Connection = conn
 	p.Channel = channel(conn, cnf.Kind, cnf.Exchange)
+	p.publisher, err = newPublisher(p.Channel, cnf)
 
-	return p, nil
+	return p, err
 }
 
 func (p PipelineRabbitMq) invoke(data []byte) bool {
@@ -71,12 +76,23 @@ func (p PipelineRabbitMq) invoke(data []byte) bool {
 
 	for _,m := range messages.Messages {
 		body, _ := json.Marshal(m.Message)
+		body, contentEncoding, err := p.encoding.encodeBytes(body, nil)
+		if err != nil {
+			logrus.
+				WithError(err).
+				WithField("component", "pipeline-rabbitmq").
+				WithField("subject", m.Subject).
+				Error("failed to encode message")
+			return false
+		}
+
 		msg := amqp.Publishing{
-			Body:    body,
-			Headers: m.Context,
+			Body:            body,
+			ContentEncoding: contentEncoding,
+			Headers:         m.Context,
 		}
 
-		err := p.Channel.Publish(p.cnf.Exchange, m.Subject, false, false, msg)
+		err = p.publisher.send(p.cnf.Exchange, m.Subject, msg)
 		if err != nil {
 			logrus.
 				WithError(err).
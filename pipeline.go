@@ -8,6 +8,7 @@ import (
 type PipelineConfig struct {
 	Type     string                `json:"type"`
 	RabbitMq *RabbitMqTargetConfig `yaml:"rabbitmq"`
+	Encoding *EncodingConfig       `yaml:"encoding"`
 }
 
 type PipeLine interface {
@@ -17,7 +18,7 @@ type PipeLine interface {
 func NewPipeLine(service *ServiceConfig) (PipeLine, error) {
 	switch service.Pipeline.Type {
 	case "rabbitmq":
-		return NewRabbitMqPipeline(service.Pipeline.RabbitMq)
+		return NewRabbitMqPipeline(service.Pipeline)
 
 	default:
 		return nil, errors.New(fmt.Sprintf("unsupported pipe: %s", service.Pipeline.Type))
@@ -0,0 +1,150 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Publish messages to RabbitMQ, waiting for the broker to confirm them if configured.
+type publisher struct {
+	publish   func(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+	confirm   bool
+	mandatory bool
+	timeout   time.Duration
+
+	mu       sync.Mutex // one message is confirmed at a time
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+
+	stateMu  sync.Mutex
+	tag      uint64       // delivery tag of the last published message, counted by the broker from 1 in confirm mode
+	waiting  chan error   // result of the last published message, nil once it's reported or timed out
+	returned *amqp.Return // of the last published message
+}
+
+func newPublisher(ch *amqp.Channel, cnf *RabbitMqTargetConfig) (*publisher, error) {
+	p := &publisher{
+		publish:   ch.Publish,
+		confirm:   cnf.Confirm || cnf.Mandatory,
+		mandatory: cnf.Mandatory,
+		timeout:   cnf.Timeout,
+	}
+
+	if 0 == p.timeout {
+		p.timeout = 5 * time.Second
+	}
+
+	if p.confirm {
+		if err := ch.Confirm(false); nil != err {
+			return nil, err
+		}
+
+		// unbuffered, so that the return is recorded before the confirmation of the same message is received
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation))
+		if p.mandatory {
+			p.returns = ch.NotifyReturn(make(chan amqp.Return))
+		}
+
+		go p.listen()
+	}
+
+	return p, nil
+}
+
+// Consume confirmations & returns as soon as the broker sends them, late ones must not block the channel.
+func (p *publisher) listen() {
+	for {
+		select {
+		case returned, ok := <-p.returns:
+			if !ok {
+				p.returns = nil
+				continue
+			}
+
+			p.stateMu.Lock()
+			p.returned = &returned
+			p.stateMu.Unlock()
+
+		case confirmation, ok := <-p.confirms:
+			if !ok {
+				p.report(errors.New("channel closed before confirming the message"))
+				return
+			}
+
+			p.stateMu.Lock()
+			late := confirmation.DeliveryTag < p.tag // of a message which timed out earlier
+			returned := p.returned
+			p.returned = nil
+			p.stateMu.Unlock()
+
+			switch {
+			case late:
+
+			case !confirmation.Ack:
+				p.report(errors.New("message is rejected by the broker"))
+
+			// the broker sends basic.return before basic.ack of unroutable messages
+			case nil != returned:
+				p.report(fmt.Errorf("message is returned by the broker: %d %s", returned.ReplyCode, returned.ReplyText))
+
+			default:
+				p.report(nil)
+			}
+		}
+	}
+}
+
+func (p *publisher) report(err error) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	if nil != p.waiting {
+		p.waiting <- err
+		p.waiting = nil
+	}
+}
+
+func (p *publisher) send(exchange string, routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.confirm {
+		return p.publish(exchange, routingKey, false, false, msg)
+	}
+
+	// the confirmation may be received before publish returns
+	waiting := make(chan error, 1)
+	p.stateMu.Lock()
+	p.tag++
+	p.waiting = waiting
+	p.returned = nil
+	p.stateMu.Unlock()
+
+	if err := p.publish(exchange, routingKey, p.mandatory, false, msg); nil != err {
+		p.stateMu.Lock()
+		p.tag--
+		p.waiting = nil
+		p.stateMu.Unlock()
+
+		return err
+	}
+
+	timeout := time.NewTimer(p.timeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-waiting:
+		return err
+
+	case <-timeout.C:
+		p.stateMu.Lock()
+		p.waiting = nil
+		p.stateMu.Unlock()
+
+		return fmt.Errorf("message is not confirmed in %s", p.timeout)
+	}
+}
@@ -0,0 +1,70 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Broker answering each published message by the prepared confirmation, return first if any.
+func newTestPublisher(answers ...func(tag uint64, p *publisher)) *publisher {
+	p := &publisher{
+		confirm:   true,
+		mandatory: true,
+		timeout:   50 * time.Millisecond,
+		confirms:  make(chan amqp.Confirmation),
+		returns:   make(chan amqp.Return),
+	}
+
+	tag := uint64(0)
+	p.publish = func(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+		tag++
+		answers[tag-1](tag, p)
+
+		return nil
+	}
+
+	go p.listen()
+
+	return p
+}
+
+func ack(tag uint64, p *publisher) {
+	p.confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+}
+
+func TestPublisherConfirms(t *testing.T) {
+	ass := assert.New(t)
+	p := newTestPublisher(
+		ack,
+		func(tag uint64, p *publisher) {
+			p.returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+			ack(tag, p)
+		},
+		func(tag uint64, p *publisher) { p.confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: false} },
+		func(tag uint64, p *publisher) {},
+		func(tag uint64, p *publisher) {
+			ack(tag-1, p) // late confirmation of the message which timed out
+			ack(tag, p)
+		},
+		func(tag uint64, p *publisher) {},
+		func(tag uint64, p *publisher) {
+			// late returns & confirmations are consumed without waiting for the next message
+			p.returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+			ack(tag-1, p)
+			p.returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+			ack(tag-1, p)
+			ack(tag, p)
+		},
+	)
+
+	ass.NoError(p.send("events", "lo.update", amqp.Publishing{}))
+	ass.EqualError(p.send("events", "lo.update", amqp.Publishing{}), "message is returned by the broker: 312 NO_ROUTE")
+	ass.EqualError(p.send("events", "lo.update", amqp.Publishing{}), "message is rejected by the broker")
+	ass.EqualError(p.send("events", "lo.update", amqp.Publishing{}), "message is not confirmed in 50ms")
+	ass.NoError(p.send("events", "lo.update", amqp.Publishing{}))
+	ass.EqualError(p.send("events", "lo.update", amqp.Publishing{}), "message is not confirmed in 50ms")
+	ass.NoError(p.send("events", "lo.update", amqp.Publishing{}))
+}
@@ -0,0 +1,436 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Confluent wire format: magic byte, 4 bytes schema ID, then the serialized data.
+const confluentMagicByte = 0
+
+type SerializerConfig struct {
+	Format   string                `yaml:"format"`  // json (default), avro, protobuf
+	Schema   string                `yaml:"schema"`  // path to .avsc file, or protobuf descriptor set (protoc --descriptor_set_out)
+	Message  string                `yaml:"message"` // protobuf message full name, ex: go1.lo.LearningObject
+	Registry *SchemaRegistryConfig `yaml:"registry"`
+}
+
+type SchemaRegistryConfig struct {
+	Url      string `yaml:"url"`
+	Subject  string `yaml:"subject"`
+	Version  string `yaml:"version"` // default: latest
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Serializer interface {
+	decode(body []byte) ([]byte, error) // serialized -> JSON
+	encode(body []byte) ([]byte, error) // JSON -> serialized
+}
+
+func NewSerializer(cnf *SerializerConfig, client *http.Client) (Serializer, error) {
+	switch cnf.Format {
+	case "", "json":
+		return jsonSerializer{}, nil
+
+	case "avro":
+		return newAvroSerializer(cnf, client)
+
+	case "protobuf":
+		return newProtobufSerializer(cnf)
+
+	default:
+		return nil, fmt.Errorf("unsupported serializer format: %s", cnf.Format)
+	}
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) decode(body []byte) ([]byte, error) { return body, nil }
+func (jsonSerializer) encode(body []byte) ([]byte, error) { return body, nil }
+
+// ***************************************************************
+// Avro
+// ***************************************************************
+
+type avroSerializer struct {
+	registry *schemaRegistry
+	codec    *goavro.Codec
+	schemaId int
+
+	mu     sync.Mutex
+	codecs map[int]*goavro.Codec
+}
+
+func newAvroSerializer(cnf *SerializerConfig, client *http.Client) (*avroSerializer, error) {
+	s := &avroSerializer{codecs: map[int]*goavro.Codec{}}
+
+	if nil != cnf.Registry {
+		s.registry = &schemaRegistry{cnf: cnf.Registry, client: client}
+	}
+
+	switch {
+	case "" != cnf.Schema:
+		schema, err := ioutil.ReadFile(cnf.Schema)
+		if nil != err {
+			return nil, err
+		}
+
+		if s.codec, err = goavro.NewCodec(string(schema)); nil != err {
+			return nil, err
+		}
+
+		// encoded messages carry the ID of the schema in the registry
+		if nil != s.registry {
+			if "" == cnf.Registry.Subject {
+				return nil, fmt.Errorf("avro serializer requires a registry subject to encode with a schema file")
+			}
+
+			if s.schemaId, err = s.registry.register(s.codec.Schema()); nil != err {
+				return nil, err
+			}
+
+			s.codecs[s.schemaId] = s.codec
+		}
+
+	case nil != s.registry && "" != cnf.Registry.Subject:
+		id, schema, err := s.registry.subject()
+		if nil != err {
+			return nil, err
+		}
+
+		if s.codec, err = goavro.NewCodec(schema); nil != err {
+			return nil, err
+		}
+
+		s.schemaId = id
+		s.codecs[id] = s.codec
+
+	case nil == s.registry:
+		return nil, fmt.Errorf("avro serializer requires a schema file or a schema registry")
+	}
+
+	return s, nil
+}
+
+func (s *avroSerializer) decode(body []byte) ([]byte, error) {
+	codec := s.codec
+
+	if nil != s.registry && len(body) > 5 && confluentMagicByte == body[0] {
+		var err error
+		if codec, err = s.codecById(int(binary.BigEndian.Uint32(body[1:5]))); nil != err {
+			return nil, err
+		}
+
+		body = body[5:]
+	}
+
+	if nil == codec {
+		return nil, fmt.Errorf("no avro schema to decode message")
+	}
+
+	native, _, err := codec.NativeFromBinary(body)
+	if nil != err {
+		return nil, err
+	}
+
+	return codec.TextualFromNative(nil, native)
+}
+
+func (s *avroSerializer) encode(body []byte) ([]byte, error) {
+	if nil == s.codec {
+		return nil, fmt.Errorf("no avro schema to encode message")
+	}
+
+	native, _, err := s.codec.NativeFromTextual(body)
+	if nil != err {
+		return nil, err
+	}
+
+	var out []byte
+	if nil != s.registry {
+		out = make([]byte, 5)
+		out[0] = confluentMagicByte
+		binary.BigEndian.PutUint32(out[1:5], uint32(s.schemaId))
+	}
+
+	return s.codec.BinaryFromNative(out, native)
+}
+
+func (s *avroSerializer) codecById(id int) (*goavro.Codec, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if codec, ok := s.codecs[id]; ok {
+		return codec, nil
+	}
+
+	schema, err := s.registry.schema(id)
+	if nil != err {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(schema)
+	if nil != err {
+		return nil, err
+	}
+
+	s.codecs[id] = codec
+
+	return codec, nil
+}
+
+// ***************************************************************
+// Protobuf
+// ***************************************************************
+
+type protobufSerializer struct {
+	message protoreflect.MessageDescriptor
+}
+
+func newProtobufSerializer(cnf *SerializerConfig) (*protobufSerializer, error) {
+	if nil != cnf.Registry {
+		return nil, fmt.Errorf("schema registry is only supported for avro, use a protobuf descriptor set file")
+	}
+
+	raw, err := ioutil.ReadFile(cnf.Schema)
+	if nil != err {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, set); nil != err {
+		return nil, err
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if nil != err {
+		return nil, err
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(cnf.Message))
+	if nil != err {
+		return nil, err
+	}
+
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a protobuf message", cnf.Message)
+	}
+
+	return &protobufSerializer{message: message}, nil
+}
+
+func (s *protobufSerializer) decode(body []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(s.message)
+	if err := proto.Unmarshal(body, msg); nil != err {
+		return nil, err
+	}
+
+	return protojson.Marshal(msg)
+}
+
+func (s *protobufSerializer) encode(body []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(s.message)
+	if err := protojson.Unmarshal(body, msg); nil != err {
+		return nil, err
+	}
+
+	return proto.Marshal(msg)
+}
+
+// ***************************************************************
+// Confluent-compatible schema registry
+// ***************************************************************
+
+type schemaRegistry struct {
+	cnf    *SchemaRegistryConfig
+	client *http.Client
+}
+
+type schemaRegistryResponse struct {
+	Id     int    `json:"id"`
+	Schema string `json:"schema"`
+}
+
+func (r *schemaRegistry) subject() (int, string, error) {
+	version := r.cnf.Version
+	if "" == version {
+		version = "latest"
+	}
+
+	res, err := r.get(fmt.Sprintf("/subjects/%s/versions/%s", url.PathEscape(r.cnf.Subject), url.PathEscape(version)))
+	if nil != err {
+		return 0, "", err
+	}
+
+	return res.Id, res.Schema, nil
+}
+
+// ID of the schema under the subject, the schema is registered if it's not found.
+func (r *schemaRegistry) register(schema string) (int, error) {
+	path := "/subjects/" + url.PathEscape(r.cnf.Subject)
+	res, err := r.post(path, schema)
+	if nil == err {
+		return res.Id, nil
+	}
+
+	if res, err = r.post(path+"/versions", schema); nil != err {
+		return 0, err
+	}
+
+	return res.Id, nil
+}
+
+func (r *schemaRegistry) schema(id int) (string, error) {
+	res, err := r.get(fmt.Sprintf("/schemas/ids/%d", id))
+	if nil != err {
+		return "", err
+	}
+
+	return res.Schema, nil
+}
+
+func (r *schemaRegistry) get(path string) (*schemaRegistryResponse, error) {
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimRight(r.cnf.Url, "/")+path, nil)
+
+	return r.do(req, path)
+}
+
+func (r *schemaRegistry) post(path string, schema string) (*schemaRegistryResponse, error) {
+	body, _ := json.Marshal(map[string]string{"schema": schema})
+	req, _ := http.NewRequest(http.MethodPost, strings.TrimRight(r.cnf.Url, "/")+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	return r.do(req, path)
+}
+
+func (r *schemaRegistry) do(req *http.Request, path string) (*schemaRegistryResponse, error) {
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if "" != r.cnf.Username {
+		req.SetBasicAuth(r.cnf.Username, r.cnf.Password)
+	}
+
+	res, err := r.client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if http.StatusOK != res.StatusCode {
+		return nil, fmt.Errorf("schema registry responded %d for %s", res.StatusCode, path)
+	}
+
+	out := &schemaRegistryResponse{}
+	if err := json.NewDecoder(res.Body).Decode(out); nil != err {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ***************************************************************
+// Target decorator: encode JSON body before delivering
+// ***************************************************************
+
+type serializedTarget struct {
+	Target
+	serializer Serializer
+}
+
+// Bodies which can't be encoded never will be, they are dead-lettered instead of retried.
+func (t *serializedTarget) encode(m *amqp.Delivery) (*amqp.Delivery, error) {
+	body, err := t.serializer.encode(m.Body)
+	if nil != err {
+		return nil, &TargetError{Action: ActionDeadLetter, Err: err}
+	}
+
+	encoded := *m
+	encoded.Body = body
+
+	return &encoded, nil
+}
+
+func (t *serializedTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	encoded, err := t.encode(m)
+	if nil != err {
+		return nil, err
+	}
+
+	return t.Target.handle(encoded)
+}
+
+// Members which can't be encoded are reported on their own, the others are delivered.
+func (t *serializedTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	errs := map[int]error{}
+	encoded := []*amqp.Delivery{}
+	indexes := []int{} // of the encoded members in the batch
+	for i, m := range ms {
+		item, err := t.encode(m)
+		if nil != err {
+			errs[i] = err
+			continue
+		}
+
+		encoded = append(encoded, item)
+		indexes = append(indexes, i)
+	}
+
+	if 0 == len(errs) {
+		return handleBatch(t.Target, encoded)
+	}
+
+	batchErr := &BatchError{Errs: errs}
+	if 0 == len(encoded) {
+		return nil, batchErr
+	}
+
+	response, err := handleBatch(t.Target, encoded)
+	switch err := err.(type) {
+	case nil:
+		if nil != response {
+			batchErr.Responses = [][]byte{response}
+		}
+
+	case *BatchError:
+		for i, memberErr := range err.Errs {
+			errs[indexes[i]] = memberErr
+		}
+
+		batchErr.Responses = err.Responses
+
+	default:
+		for _, i := range indexes {
+			errs[i] = err
+		}
+	}
+
+	return nil, batchErr
+}
+
+// Forwarded to async targets only, see asyncTarget.
+func (t *serializedTarget) handleAsync(m *amqp.Delivery, done func(error)) {
+	encoded, err := t.encode(m)
+	if nil != err {
+		done(err)
+		return
+	}
+
+	t.Target.(AsyncTarget).handleAsync(encoded, done)
+}
@@ -0,0 +1,157 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+const avroUserSchema = `{
+  "type": "record",
+  "name": "User",
+  "fields": [
+    {"name": "id",   "type": "long"},
+    {"name": "mail", "type": "string"}
+  ]
+}`
+
+func TestAvroSchemaRegistry(t *testing.T) {
+	ass := assert.New(t)
+	registry := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/subjects/core-user-value/versions/latest":
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "version": 1, "schema": avroUserSchema})
+
+			case "/schemas/ids/7":
+				json.NewEncoder(w).Encode(map[string]interface{}{"schema": avroUserSchema})
+
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer registry.Close()
+
+	cnf := &SerializerConfig{
+		Format:   "avro",
+		Registry: &SchemaRegistryConfig{Url: registry.URL, Subject: "core-user-value"},
+	}
+
+	s, err := NewSerializer(cnf, http.DefaultClient)
+	ass.NoError(err)
+
+	encoded, err := s.encode([]byte(`{"id": 1, "mail": "1@1.1"}`))
+	ass.NoError(err)
+	ass.Equal([]byte{0, 0, 0, 0, 7}, encoded[0:5], "should use confluent wire format")
+
+	// decoding resolves the schema by ID from the registry.
+	decoder, _ := NewSerializer(&SerializerConfig{Format: "avro", Registry: &SchemaRegistryConfig{Url: registry.URL}}, http.DefaultClient)
+	decoded, err := decoder.decode(encoded)
+	ass.NoError(err)
+	ass.JSONEq(`{"id": 1, "mail": "1@1.1"}`, string(decoded))
+}
+
+func TestAvroSchemaFileWithRegistry(t *testing.T) {
+	ass := assert.New(t)
+	paths := []string{}
+	registry := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+
+			switch r.URL.EscapedPath() {
+			case "/subjects/core%2Fuser-value/versions":
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": 9})
+
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer registry.Close()
+
+	schema := filepath.Join(t.TempDir(), "user.avsc")
+	ass.NoError(ioutil.WriteFile(schema, []byte(avroUserSchema), 0644))
+
+	s, err := NewSerializer(&SerializerConfig{
+		Format:   "avro",
+		Schema:   schema,
+		Registry: &SchemaRegistryConfig{Url: registry.URL, Subject: "core/user-value"},
+	}, http.DefaultClient)
+	ass.NoError(err)
+	ass.Equal([]string{"POST /subjects/core%2Fuser-value", "POST /subjects/core%2Fuser-value/versions"}, paths)
+
+	encoded, err := s.encode([]byte(`{"id": 1, "mail": "1@1.1"}`))
+	ass.NoError(err)
+	ass.Equal([]byte{0, 0, 0, 0, 9}, encoded[0:5])
+
+	// schema ID can't be resolved without subject
+	_, err = NewSerializer(&SerializerConfig{Format: "avro", Schema: schema, Registry: &SchemaRegistryConfig{Url: registry.URL}}, http.DefaultClient)
+	ass.Error(err)
+}
+
+func TestRejectUndeserializableBody(t *testing.T) {
+	ass := assert.New(t)
+	decoder, err := NewSerializer(&SerializerConfig{Format: "avro", Registry: &SchemaRegistryConfig{Url: "http://127.0.0.1:1"}}, http.DefaultClient)
+	ass.NoError(err)
+
+	terminate := make(chan bool, 1)
+	stream := make(chan amqp.Delivery, 1)
+	stream <- amqp.Delivery{RoutingKey: "user.create", Body: []byte("not avro")}
+
+	handled, rejected := false, false
+	loop(
+		terminate,
+		stream,
+		&ServiceConfig{Name: "core-user", decoder: decoder},
+		func(m *amqp.Delivery) { handled = true },
+		func(uuid string, m amqp.Delivery, failedValidation bool) {
+			rejected = !failedValidation
+			terminate <- true
+		},
+	)
+
+	ass.True(rejected)
+	ass.False(handled)
+}
+
+func TestDeadLetterUnencodableBody(t *testing.T) {
+	ass := assert.New(t)
+	schema := filepath.Join(t.TempDir(), "user.avsc")
+	ass.NoError(ioutil.WriteFile(schema, []byte(avroUserSchema), 0644))
+
+	s, err := NewSerializer(&SerializerConfig{Format: "avro", Schema: schema}, http.DefaultClient)
+	ass.NoError(err)
+
+	inner := &fakeBatchTarget{errs: []error{&BatchError{Errs: map[int]error{1: errors.New("failed")}}}}
+	target := &serializedTarget{Target: inner, serializer: s}
+
+	_, err = target.handle(&amqp.Delivery{Body: []byte(`{"id": "one"}`)})
+	targetErr, ok := err.(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDeadLetter, targetErr.Action)
+
+	_, err = target.handleBatch([]*amqp.Delivery{
+		{Body: []byte(`{"id": 1, "mail": "1@1.1"}`)},
+		{Body: []byte(`{"id": "two"}`)},
+		{Body: []byte(`{"id": 3, "mail": "3@3.3"}`)},
+	})
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Len(inner.batches[0], 2, "encoded members are delivered")
+	ass.Len(batchErr.Errs, 2)
+	ass.Equal(ActionDeadLetter, batchErr.Errs[1].(*TargetError).Action)
+	ass.EqualError(batchErr.Errs[2], "failed", "failures of the target are mapped to the batch members")
+
+	done := make(chan error, 1)
+	target = &serializedTarget{Target: &fakeAsyncTarget{}, serializer: s}
+	target.handleAsync(&amqp.Delivery{Body: []byte(`{"id": "one"}`)}, func(err error) { done <- err })
+	targetErr, ok = (<-done).(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDeadLetter, targetErr.Action)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tidwall/gjson"
@@ -21,19 +22,33 @@ type Service struct {
 	target   Target
 	pipeline PipeLine
 	retryKey int
+	retryMu  sync.Mutex
 	worker   int
+
+	explosions explosions
+	attempts   deadLetterAttempts
+}
+
+// Channel settling the consumed messages.
+type acknowledger interface {
+	Ack(tag uint64, multiple bool) error
+	Nack(tag uint64, multiple bool, requeue bool) error
 }
 
 type ServiceConfig struct {
-	Split           int             `yaml:"split"`
-	ExcludeMonolith bool            `yaml:"exclude-monolith"`
-	Name            string          `yaml:"name"`
-	Queue           string          `yaml:"queue"`
-	Routes          []RouteConfig   `yaml:"routes"`
-	Target          *TargetConfig   `yaml:"target"`
-	Pipeline        *PipelineConfig `yaml:"pipeline"`
-	DeadLetter      *DeadLetter     `yaml:"dead-letter"`
-	Worker          int             `yaml:"worker"`
+	Split           int               `yaml:"split"`
+	ExcludeMonolith bool              `yaml:"exclude-monolith"`
+	Name            string            `yaml:"name"`
+	Queue           string            `yaml:"queue"`
+	Routes          []RouteConfig     `yaml:"routes"`
+	Target          *TargetConfig     `yaml:"target"`
+	Pipeline        *PipelineConfig   `yaml:"pipeline"`
+	DeadLetter      *DeadLetter       `yaml:"dead-letter"`
+	Worker          int               `yaml:"worker"`
+	Decode          *SerializerConfig `yaml:"decode"`
+	Batch           *BatchConfig      `yaml:"batch"`
+
+	decoder Serializer
 }
 
 func (s *ServiceConfig) onParse(cnf *AppConfig) {
@@ -53,6 +68,38 @@ func (s *ServiceConfig) onParse(cnf *AppConfig) {
 			s.DeadLetter = cnf.DeadLetter
 		}
 	}
+
+	if nil != s.Batch {
+		s.Batch.onParse()
+	}
+
+	if nil != s.Target && nil != s.Target.Http {
+		s.Target.Http.onParse(cnf.HttpClient)
+
+		// a batch is one request, the routing key of each raw body would be lost
+		if nil != s.Batch && "raw" == s.Target.Http.Format {
+			logrus.
+				WithField("service", s.Name).
+				Panic("bad config, raw format of http target can't be batched")
+		}
+	}
+}
+
+// Number of unacknowledged messages the broker may deliver to a consumer.
+func (c *ServiceConfig) prefetch() int {
+	if nil != c.Batch {
+		return c.Batch.Size
+	}
+
+	if nil != c.Target && nil != c.Target.Kafka && "async" == c.Target.Kafka.Mode {
+		if c.Target.Kafka.InFlight > 0 {
+			return c.Target.Kafka.InFlight
+		}
+
+		return 100
+	}
+
+	return 1
 }
 
 func (c *ServiceConfig) routingKeys() []string {
@@ -73,6 +120,13 @@ func NewService(appConfig *AppConfig, serviceConfig *ServiceConfig, worker int)
 		return nil, err
 	}
 
+	if nil != serviceConfig.Decode && nil == serviceConfig.decoder {
+		serviceConfig.decoder, err = NewSerializer(serviceConfig.Decode, appConfig.HttpClient.Get())
+		if nil != err {
+			return nil, err
+		}
+	}
+
 	if nil == appConfig.RabbitMq {
 		return nil, errors.New("no rabbitmq connection configured")
 	}
@@ -126,9 +180,12 @@ func (c *Service) start(ctx context.Context, terminate chan bool) {
 
 	app.groupProcess.Add(1)
 
-	var handler = c.handler(c.ch)
+	var handler = c.consumer(c.ch)
+	prefetch := c.cnf.prefetch()
 	if c.cnf.Split > 0 {
-		handler = c.dispatchToServiceWorker(c.ch)
+		// messages are dispatched one at a time, batches & async targets are on the workers
+		handler = c.dispatchToServiceWorker(c.ch, c.chGroup.Publish)
+		prefetch = 1
 		for i := 0; i < c.cnf.Split; i++ {
 			go c.startServiceWorker(i, ctx, terminate)
 		}
@@ -136,11 +193,11 @@ func (c *Service) start(ctx context.Context, terminate chan bool) {
 
 	loop(
 		terminate,
-		stream(c.ch, "events", c.cnf.Queue, c.cnf.routingKeys()),
+		stream(c.ch, "events", c.cnf.Queue, c.cnf.routingKeys(), prefetch),
 		c.cnf,
 		handler,
 		func(uuid string, m amqp.Delivery, failedValidation bool) {
-			c.ch.Nack(m.DeliveryTag, true, false)
+			c.ch.Nack(m.DeliveryTag, false, false)
 
 			if failedValidation {
 				counter, _ := promFilteredMessageCounter.GetMetricWithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey)
@@ -154,7 +211,7 @@ func (c *Service) start(ctx context.Context, terminate chan bool) {
 	)
 }
 
-func (c *Service) handler(ch *amqp.Channel) func(m *amqp.Delivery) {
+func (c *Service) handler(ch acknowledger) func(m *amqp.Delivery) {
 	return func(m *amqp.Delivery) {
 		defer func() {
 			if err := recover(); nil != err {
@@ -165,35 +222,32 @@ func (c *Service) handler(ch *amqp.Channel) func(m *amqp.Delivery) {
 			}
 		}()
 
-		m.Headers["X-QUEUE"] = c.cnf.Queue
-
-		if xRoutingKey, ok := m.Headers["X-ROUTING-KEY"]; ok {
-			m.Headers["X-ORIGINAL-ROUTING-KEY"] = m.RoutingKey
-			m.RoutingKey = xRoutingKey.(string)
+		c.prepare(m)
 
-			delete(m.Headers, "X-ROUTING-KEY")
-		}
-
-		if nil != c.cnf.DeadLetter {
-			if !m.Redelivered {
-				c.cnf.DeadLetter.start()
-			}
-		}
+		var retryAfter time.Duration
+		settled := false // rejected by the target, counted apart from successes
 
 		retry(
 			func() bool {
-				isDeathMessage := nil != c.cnf.DeadLetter && c.cnf.DeadLetter.HandleFailure(c.cnf, m)
-				if isDeathMessage {
-					ch.Nack(m.DeliveryTag, false, false)
+				if c.deadLetter(ch, m) {
 					return true
 				}
 
-				response, err := c.target.handle(m)
+				response, err := c.deliver(m)
 				if err != nil {
+					if targetErr, ok := err.(*TargetError); ok && ActionRetry != targetErr.Action {
+						settled = c.settle(ch, m, targetErr)
+						return settled
+					}
+
 					c.log(err).
 						WithField("msg.routingKey", m.RoutingKey).
 						Errorf("failed execute the target")
 
+					if targetErr, ok := err.(*TargetError); ok {
+						retryAfter = targetErr.RetryAfter
+					}
+
 					return false
 				}
 
@@ -205,12 +259,13 @@ func (c *Service) handler(ch *amqp.Channel) func(m *amqp.Delivery) {
 					return false
 				}
 
-				ch.Ack(m.DeliveryTag, true)
+				// other messages of the channel may still be in flight: batches, async targets
+				ch.Ack(m.DeliveryTag, false)
+				c.forget(m)
 				return true
 			},
 			func() {
-				var retryInterval time.Duration
-				retryInterval, c.retryKey = app.config.NextIdleTime(c.retryKey)
+				retryInterval := c.retryInterval(retryAfter)
 
 				promFailureMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
 				promRetryMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
@@ -223,13 +278,183 @@ func (c *Service) handler(ch *amqp.Channel) func(m *amqp.Delivery) {
 				time.Sleep(retryInterval)
 			},
 			func() {
-				promSuccessMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+				if !settled {
+					promSuccessMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+				}
 			},
 		)
 	}
 }
 
-func (c *Service) dispatchToServiceWorker(ch *amqp.Channel) func(m *amqp.Delivery) {
+// Wait time before the next retry, retryAfter of the target takes precedence over the retry intervals.
+// Handlers of batches & async targets retry from other goroutines than the consumer.
+func (c *Service) retryInterval(retryAfter time.Duration) time.Duration {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+
+	var retryInterval time.Duration
+	retryInterval, c.retryKey = app.config.NextIdleTime(c.retryKey)
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	return retryInterval
+}
+
+// Dead-letter the message if its attempts meet the condition, true if the message is settled.
+func (c *Service) deadLetter(ch acknowledger, m *amqp.Delivery) bool {
+	dl := c.cnf.DeadLetter
+	if nil == dl || !c.attempts.due(dl, m) || !dl.deliver(c.cnf, m) {
+		return false
+	}
+
+	ch.Nack(m.DeliveryTag, false, false)
+	c.forget(m)
+
+	return true
+}
+
+// Forget the state kept for the retries of a settled message.
+func (c *Service) forget(m *amqp.Delivery) {
+	c.attempts.forget(m)
+
+	if "" != c.cnf.explodePath(m.RoutingKey) {
+		c.explosions.forget(messageKey(m))
+	}
+}
+
+// Drop or dead-letter the message as the target asked, false if the message needs to be retried.
+func (c *Service) settle(ch acknowledger, m *amqp.Delivery, err *TargetError) bool {
+	if !c.reject(m, err) {
+		return false
+	}
+
+	if ActionDrop == err.Action {
+		ch.Ack(m.DeliveryTag, false)
+	} else {
+		ch.Nack(m.DeliveryTag, false, false)
+	}
+
+	c.forget(m)
+
+	return true
+}
+
+// Deliver the rejected message to the dead-letter if the target asked, false if it couldn't be.
+// Exploded elements are rejected on their own, without settling the source message.
+func (c *Service) reject(m *amqp.Delivery, err *TargetError) bool {
+	c.log(err).
+		WithField("msg.routingKey", m.RoutingKey).
+		WithField("action", err.Action).
+		Error("target rejected the message")
+
+	switch err.Action {
+	case ActionDrop:
+
+	case ActionDeadLetter:
+		if nil == c.cnf.DeadLetter {
+			c.log(nil).
+				WithField("msg.routingKey", m.RoutingKey).
+				Warning("no dead-letter is configured, message is dropped")
+		} else if !c.cnf.DeadLetter.deliver(c.cnf, m) {
+			return false
+		}
+
+	default:
+		return false
+	}
+
+	promRejectedMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey, err.Action).Inc()
+
+	return true
+}
+
+func (c *Service) prepare(m *amqp.Delivery) {
+	m.Headers["X-QUEUE"] = c.cnf.Queue
+
+	if xRoutingKey, ok := m.Headers["X-ROUTING-KEY"]; ok {
+		m.Headers["X-ORIGINAL-ROUTING-KEY"] = m.RoutingKey
+		m.RoutingKey = xRoutingKey.(string)
+
+		delete(m.Headers, "X-ROUTING-KEY")
+	}
+}
+
+// Handler of messages consumed from the channel, batching messages if configured.
+func (c *Service) consumer(ch acknowledger) func(m *amqp.Delivery) {
+	if nil != c.cnf.Batch {
+		return newBatcher(c, ch).add
+	}
+
+	if target, ok := asyncTarget(c.target); ok {
+		return c.asyncHandler(ch, target)
+	}
+
+	return c.handler(ch)
+}
+
+// Serialized targets are async if the wrapped target is.
+func asyncTarget(target Target) (AsyncTarget, bool) {
+	if serialized, ok := target.(*serializedTarget); ok {
+		if _, ok := serialized.Target.(AsyncTarget); !ok {
+			return nil, false
+		}
+	}
+
+	async, ok := target.(AsyncTarget)
+
+	return async, ok
+}
+
+// Send messages without waiting for the target, each message is settled when the target reports its result.
+func (c *Service) asyncHandler(ch acknowledger, target AsyncTarget) func(m *amqp.Delivery) {
+	handler := c.handler(ch)
+
+	return func(m *amqp.Delivery) {
+		// exploded messages are delivered one by one
+		if "" != c.cnf.explodePath(m.RoutingKey) {
+			handler(m)
+			return
+		}
+
+		c.prepare(m)
+
+		// attempts are counted per message, many of them are in flight
+		if c.deadLetter(ch, m) {
+			return
+		}
+
+		target.handleAsync(m, func(err error) {
+			if nil == err {
+				ch.Ack(m.DeliveryTag, false)
+				c.forget(m)
+				promSuccessMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+				return
+			}
+
+			if targetErr, ok := err.(*TargetError); ok && ActionRetry != targetErr.Action && c.settle(ch, m, targetErr) {
+				return
+			}
+
+			retryInterval := c.retryInterval(0)
+
+			promFailureMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+			promRetryMessageCounter.WithLabelValues(c.cnf.Queue, c.cnf.Name, m.RoutingKey).Inc()
+
+			c.log(err).
+				WithField("msg.routingKey", m.RoutingKey).
+				Errorf("failed handling m, retry in: %s", retryInterval)
+
+			// callbacks must not block the target, the message stays unacknowledged until it's requeued
+			time.AfterFunc(retryInterval, func() { ch.Nack(m.DeliveryTag, false, true) })
+		})
+	}
+}
+
+func (c *Service) dispatchToServiceWorker(
+	ch acknowledger,
+	publish func(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error,
+) func(m *amqp.Delivery) {
 	return func(m *amqp.Delivery) {
 		idFromPayload := gjson.GetBytes(m.Body, "id").Int()
 		destinationId := idFromPayload % int64(c.cnf.Split)
@@ -240,16 +465,21 @@ func (c *Service) dispatchToServiceWorker(ch *amqp.Channel) func(m *amqp.Deliver
 		m.Headers["X-SERVICE"] = c.cnf.Name
 		m.Headers["X-ROUTING-KEY"] = m.RoutingKey
 
-		err := c.chGroup.Publish("consumer_group", routingKey, false, false, convert(m))
+		err := publish("consumer_group", routingKey, false, false, convert(m))
 		if nil != err {
+			retryInterval := c.retryInterval(0)
+
 			c.log(err).
 				WithField("routingKey", routingKey).
-				Error("[group.dispatch] failed dispatching m to sub-consumer")
+				Errorf("[group.dispatch] failed dispatching m to sub-consumer, retry in: %s", retryInterval)
+
+			ch.Nack(m.DeliveryTag, false, true)
+			time.Sleep(retryInterval)
 		} else {
 			c.log(nil).
 				WithField("routingKey", routingKey).
 				Info("[group.dispatch] message is dispatched to sub-consumer")
-			ch.Ack(m.DeliveryTag, true)
+			ch.Ack(m.DeliveryTag, false)
 		}
 	}
 }
@@ -273,9 +503,11 @@ func (c *Service) startServiceWorker(id int, ctx context.Context, terminate chan
 	app.groupProcess.Add(1)
 	loop(
 		terminate,
-		stream(ch, "consumer_group", queueName, []string{queueName}),
-		nil,
-		c.handler(ch),
+		stream(ch, "consumer_group", queueName, []string{queueName}, c.cnf.prefetch()),
 		nil,
+		c.consumer(ch),
+		func(uuid string, m amqp.Delivery, failedValidation bool) {
+			ch.Nack(m.DeliveryTag, false, false)
+		},
 	)
 }
@@ -0,0 +1,189 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Async target reporting the queued results, one per message.
+type fakeAsyncTarget struct {
+	fakeBatchTarget
+	results []error
+}
+
+func (t *fakeAsyncTarget) handleAsync(m *amqp.Delivery, done func(error)) {
+	t.batches = append(t.batches, []*amqp.Delivery{m})
+
+	var err error
+	if 0 != len(t.results) {
+		err, t.results = t.results[0], t.results[1:]
+	}
+
+	go done(err)
+}
+
+func TestAsyncHandler(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	ch := &fakeChannel{}
+	target := &fakeAsyncTarget{results: []error{
+		nil,
+		&TargetError{Action: ActionDrop, Err: errors.New("invalid")},
+		&TargetError{Action: ActionDeadLetter, Err: errors.New("rejected")},
+		errors.New("failed"),
+	}}
+	c := &Service{
+		cnf:    &ServiceConfig{Name: "async-service", Queue: "async-queue"},
+		target: target,
+	}
+
+	handler := c.consumer(ch)
+	for tag := uint64(1); tag <= 4; tag++ {
+		handler(batchMessage(tag))
+	}
+
+	ass.Eventually(func() bool {
+		acked, requeued, rejected := ch.settled()
+		return 2 == len(acked) && 1 == len(requeued) && 1 == len(rejected)
+	}, time.Second, 5*time.Millisecond)
+
+	acked, requeued, rejected := ch.settled()
+	ass.ElementsMatch([]uint64{1, 2}, acked)
+	ass.Equal([]uint64{3}, rejected)
+	ass.Equal([]uint64{4}, requeued, "failed messages are retried")
+}
+
+func TestAsyncHandlerDeadLetter(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	deadLetters := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&deadLetters, 1)
+	}))
+	defer server.Close()
+
+	ch := &fakeChannel{}
+	target := &fakeAsyncTarget{results: []error{errors.New("failed")}}
+	c := &Service{
+		cnf: &ServiceConfig{
+			Name:  "async-service",
+			Queue: "async-queue",
+			DeadLetter: &DeadLetter{
+				Condition: DeadLetterCondition{Attempts: 2},
+				Target:    "http",
+				Http:      &DeadLetterHttpTarget{Method: http.MethodPost, Url: server.URL},
+			},
+		},
+		target: target,
+	}
+
+	handler := c.consumer(ch)
+	handler(batchMessage(1))
+	ass.Eventually(func() bool {
+		_, requeued, _ := ch.settled()
+		return 1 == len(requeued)
+	}, time.Second, 5*time.Millisecond)
+
+	// fresh messages in flight don't restart the attempts of others
+	handler(batchMessage(2))
+	m := batchMessage(1)
+	m.Redelivered = true
+	handler(m)
+
+	ass.Eventually(func() bool {
+		acked, _, _ := ch.settled()
+		return 1 == len(acked)
+	}, time.Second, 5*time.Millisecond)
+
+	acked, _, rejected := ch.settled()
+	ass.Equal([]uint64{2}, acked)
+	ass.Equal([]uint64{1}, rejected)
+	ass.Equal(int32(1), atomic.LoadInt32(&deadLetters))
+	ass.Len(target.batches, 2)
+}
+
+func TestAsyncSerializedTarget(t *testing.T) {
+	ass := assert.New(t)
+
+	_, ok := asyncTarget(&serializedTarget{Target: &fakeAsyncTarget{}, serializer: jsonSerializer{}})
+	ass.True(ok, "async targets stay async when encoded")
+
+	_, ok = asyncTarget(&serializedTarget{Target: &fakeBatchTarget{}, serializer: jsonSerializer{}})
+	ass.False(ok)
+}
+
+func TestSettleRejectedMessage(t *testing.T) {
+	ass := assert.New(t)
+	ch := &fakeChannel{}
+	target := &fakeBatchTarget{errs: []error{
+		&TargetError{Action: ActionDrop, Err: errors.New("invalid")},
+		&TargetError{Action: ActionDeadLetter, Err: errors.New("rejected")},
+	}}
+	c := &Service{
+		cnf:    &ServiceConfig{Name: "settle-service", Queue: "settle-queue"},
+		target: target,
+	}
+
+	// counters are global, only their increments are asserted
+	success := promSuccessMessageCounter.WithLabelValues("settle-queue", "settle-service", "user.create")
+	dropped := promRejectedMessageCounter.WithLabelValues("settle-queue", "settle-service", "user.create", ActionDrop)
+	deadLettered := promRejectedMessageCounter.WithLabelValues("settle-queue", "settle-service", "user.create", ActionDeadLetter)
+	before := []float64{testutil.ToFloat64(success), testutil.ToFloat64(dropped), testutil.ToFloat64(deadLettered)}
+
+	handler := c.handler(ch)
+	handler(batchMessage(1))
+	handler(batchMessage(2))
+
+	// dead-letter without configuration is dropped, with a warning
+	acked, requeued, rejected := ch.settled()
+	ass.Equal([]uint64{1}, acked)
+	ass.Equal([]uint64{2}, rejected)
+	ass.Empty(requeued)
+
+	ass.Equal(before[0], testutil.ToFloat64(success))
+	ass.Equal(before[1]+1, testutil.ToFloat64(dropped))
+	ass.Equal(before[2]+1, testutil.ToFloat64(deadLettered))
+}
+
+func TestDispatchToServiceWorker(t *testing.T) {
+	withRetryIntervals(t)
+
+	ass := assert.New(t)
+	ch := &fakeChannel{}
+	c := &Service{cnf: &ServiceConfig{Name: "split-service", Queue: "split-queue", Split: 2}}
+
+	published := []string{}
+	failures := []error{nil, errors.New("channel closed"), nil}
+	handler := c.dispatchToServiceWorker(ch, func(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+		err := failures[0]
+		failures = failures[1:]
+		if nil == err {
+			published = append(published, key)
+		}
+
+		return err
+	})
+
+	for tag := uint64(1); tag <= 3; tag++ {
+		m := batchMessage(tag)
+		m.Body = []byte(`{"id": ` + strconv.FormatUint(tag, 10) + `}`)
+		handler(m)
+	}
+
+	acked, requeued, rejected := ch.settled()
+	ass.Equal([]uint64{1, 3}, acked, "dispatched messages are acked one by one")
+	ass.Equal([]uint64{2}, requeued, "failed dispatches are retried")
+	ass.Empty(rejected)
+	ass.Equal([]string{"split-queue:1", "split-queue:1"}, published)
+}
@@ -0,0 +1,270 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/tidwall/gjson"
+)
+
+type ElasticsearchTargetConfig struct {
+	Url        string                      `yaml:"url"`         // ex: http://localhost:9200
+	Index      string                      `yaml:"index"`       // placeholders: %routing-key%, %routing-key.N%, %service%
+	Id         string                      `yaml:"id"`          // routing-key, header:NAME, body:GJSON_PATH. Default: generated by Elasticsearch, required by update & delete
+	Routing    string                      `yaml:"routing"`     // routing-key, header:NAME, body:GJSON_PATH. Default: no custom routing
+	Source     string                      `yaml:"source"`      // gjson path of the document in body. Default: the whole body
+	Pipeline   string                      `yaml:"pipeline"`    // ingest pipeline of index & create actions
+	Actions    []ElasticsearchActionConfig `yaml:"actions"`     // first action matching the routing key. Default: index
+	OnRejected string                      `yaml:"on-rejected"` // items rejected by Elasticsearch (4xx): dead-letter (default), drop, retry
+	Timeout    time.Duration               `yaml:"timeout"`     // of each bulk request. Default: 30s
+	Auth       *AuthConfig                 `yaml:"auth"`        // basic, bearer, aws-sigv4 (service: es) for Amazon OpenSearch
+}
+
+type ElasticsearchActionConfig struct {
+	RoutingKey string `yaml:"routing-key"` // binding key, * matches one word, # zero or more
+	Action     string `yaml:"action"`      // index, create, update (upsert the document), delete
+}
+
+// Item of bulk responses, keyed by the action.
+type elasticsearchBulkItem map[string]struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type ElasticsearchTarget struct {
+	cnf     *ElasticsearchTargetConfig
+	service string
+	client  *http.Client
+	auth    Authenticator
+}
+
+func NewElasticsearchTarget(service string, cnf *ElasticsearchTargetConfig, client *http.Client) (Target, error) {
+	if nil == cnf || "" == cnf.Url || "" == cnf.Index {
+		return nil, errors.New("missing elasticsearch url or index")
+	}
+
+	for _, action := range cnf.Actions {
+		switch action.Action {
+		case "index", "create", "update", "delete":
+		default:
+			return nil, fmt.Errorf("unsupported elasticsearch action: %s", action.Action)
+		}
+
+		if "index" != action.Action && "create" != action.Action && "" == cnf.Id {
+			return nil, fmt.Errorf("missing elasticsearch document id of %s action", action.Action)
+		}
+	}
+
+	if "" == cnf.OnRejected {
+		cnf.OnRejected = ActionDeadLetter
+	}
+
+	switch cnf.OnRejected {
+	case ActionDeadLetter, ActionDrop, ActionRetry:
+	default:
+		return nil, fmt.Errorf("unsupported elasticsearch rejection action: %s", cnf.OnRejected)
+	}
+
+	if 0 == cnf.Timeout {
+		cnf.Timeout = 30 * time.Second
+	}
+
+	auth, err := cnf.Auth.get()
+	if nil != err {
+		return nil, err
+	}
+
+	return &ElasticsearchTarget{cnf: cnf, service: service, client: client, auth: auth}, nil
+}
+
+func (t *ElasticsearchTarget) start() error     { return nil }
+func (t *ElasticsearchTarget) terminate() error { return nil }
+
+func (t *ElasticsearchTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	_, err := t.handleBatch([]*amqp.Delivery{m})
+	if batchErr, ok := err.(*BatchError); ok {
+		return nil, batchErr.Errs[0]
+	}
+
+	return nil, err
+}
+
+// Messages are sent in one bulk request, only the failed items are reported in a BatchError.
+func (t *ElasticsearchTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	errs := map[int]error{}
+	indexes := []int{} // member of each bulk item
+	body := &bytes.Buffer{}
+	for i, m := range ms {
+		lines, err := t.lines(m)
+		if nil != err {
+			errs[i] = &TargetError{Action: t.cnf.OnRejected, Err: err}
+			continue
+		}
+
+		body.Write(lines)
+		indexes = append(indexes, i)
+	}
+
+	if 0 != len(indexes) {
+		items, err := t.bulk(body.Bytes())
+		if nil != err {
+			return nil, err
+		}
+
+		if len(items) != len(indexes) {
+			return nil, fmt.Errorf("elasticsearch returned %d items of %d", len(items), len(indexes))
+		}
+
+		for i, item := range items {
+			if err := t.itemError(item); nil != err {
+				errs[indexes[i]] = err
+			}
+		}
+	}
+
+	if 0 != len(errs) {
+		return nil, &BatchError{Errs: errs}
+	}
+
+	return nil, nil
+}
+
+func (t *ElasticsearchTarget) action(routingKey string) string {
+	for _, action := range t.cnf.Actions {
+		if matchRoutingKey(action.RoutingKey, routingKey) {
+			return action.Action
+		}
+	}
+
+	return "index"
+}
+
+// Action & document lines of the message, invalid messages must not leave a partial item in the bulk body.
+func (t *ElasticsearchTarget) lines(m *amqp.Delivery) ([]byte, error) {
+	action := t.action(m.RoutingKey)
+	meta := map[string]string{"_index": render(t.cnf.Index, t.service, m)}
+
+	if "" != t.cnf.Id {
+		id, ok := selectValue(t.cnf.Id, m)
+		if !ok || 0 == len(id) {
+			return nil, fmt.Errorf("elasticsearch document id not found: %s", t.cnf.Id)
+		}
+
+		meta["_id"] = string(id)
+	}
+
+	if "" != t.cnf.Routing {
+		if routing, ok := selectValue(t.cnf.Routing, m); ok {
+			meta["routing"] = string(routing)
+		}
+	}
+
+	if "" != t.cnf.Pipeline && ("index" == action || "create" == action) {
+		meta["pipeline"] = t.cnf.Pipeline
+	}
+
+	body := &bytes.Buffer{}
+	line, _ := json.Marshal(map[string]interface{}{action: meta})
+	body.Write(line)
+	body.WriteByte('\n')
+
+	if "delete" == action {
+		return body.Bytes(), nil
+	}
+
+	doc := m.Body
+	if "" != t.cnf.Source {
+		result := gjson.GetBytes(m.Body, t.cnf.Source)
+		if !result.IsObject() {
+			return nil, fmt.Errorf("elasticsearch document not found: %s", t.cnf.Source)
+		}
+
+		doc = []byte(result.Raw)
+	}
+
+	if !json.Valid(doc) {
+		return nil, errors.New("elasticsearch document is not valid JSON")
+	}
+
+	if "update" == action {
+		doc, _ = json.Marshal(map[string]interface{}{"doc": json.RawMessage(doc), "doc_as_upsert": true})
+	}
+
+	// documents must be on a single line
+	compact := &bytes.Buffer{}
+	json.Compact(compact, doc)
+	body.Write(compact.Bytes())
+	body.WriteByte('\n')
+
+	return body.Bytes(), nil
+}
+
+func (t *ElasticsearchTarget) bulk(body []byte) ([]elasticsearchBulkItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.cnf.Timeout)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(t.cnf.Url, "/")+"/_bulk", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if err := t.auth.authenticate(req, body); nil != err {
+		return nil, err
+	}
+
+	res, err := t.client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("elasticsearch bulk request failed: %s", res.Status)
+	}
+
+	response := struct {
+		Errors bool                    `json:"errors"`
+		Items  []elasticsearchBulkItem `json:"items"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); nil != err {
+		return nil, err
+	}
+
+	return response.Items, nil
+}
+
+// Too many requests & server errors are retried, other rejections take the configured action.
+func (t *ElasticsearchTarget) itemError(item elasticsearchBulkItem) error {
+	for action, result := range item {
+		switch {
+		case result.Status < 300:
+			return nil
+
+		case "delete" == action && http.StatusNotFound == result.Status:
+			return nil
+		}
+
+		err := fmt.Errorf("elasticsearch %s failed with %d: %s", action, result.Status, result.Error)
+
+		logrus.
+			WithField("component", "target-elasticsearch").
+			WithField("action", action).
+			WithField("status", result.Status).
+			Error(string(result.Error))
+
+		if http.StatusTooManyRequests == result.Status || result.Status >= 500 {
+			return err
+		}
+
+		return &TargetError{Action: t.cnf.OnRejected, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElasticsearchTarget(t *testing.T) {
+	ass := assert.New(t)
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		ass.Equal("/_bulk", r.URL.Path)
+		ass.Equal("application/x-ndjson", r.Header.Get("Content-Type"))
+		ass.Equal("Bearer secret", r.Header.Get("Authorization"))
+
+		w.Write([]byte(`{"errors": true, "items": [
+			{"index": {"_id": "1", "status": 201}},
+			{"update": {"_id": "2", "status": 429, "error": {"type": "es_rejected_execution_exception"}}},
+			{"index": {"_id": "3", "status": 400, "error": {"type": "mapper_parsing_exception"}}},
+			{"delete": {"_id": "5", "status": 404}}
+		]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("ES_TOKEN", "secret")
+	target, err := NewElasticsearchTarget("lo-index", &ElasticsearchTargetConfig{
+		Url:      server.URL + "/",
+		Index:    "%service%-%routing-key.0%",
+		Id:       "body:id",
+		Source:   "lo",
+		Pipeline: "lo",
+		Actions: []ElasticsearchActionConfig{
+			{RoutingKey: "*.update", Action: "update"},
+			{RoutingKey: "*.delete", Action: "delete"},
+		},
+		Auth: &AuthConfig{Type: "bearer", Token: &Secret{Env: "ES_TOKEN"}},
+	}, http.DefaultClient)
+	ass.NoError(err)
+
+	_, err = target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		{RoutingKey: "lo.create", Body: []byte(`{"id": 1, "lo": {"title": "Go",
+			"tags": ["go"]}}`)},
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 2, "lo": {"title": "Rust"}}`)},
+		{RoutingKey: "lo.create", Body: []byte(`{"id": 3, "lo": {"title": 1}}`)},
+		{RoutingKey: "lo.create", Body: []byte(`{"lo": {"title": "no id"}}`)},
+		{RoutingKey: "lo.delete", Body: []byte(`{"id": 5}`)},
+	})
+
+	ass.Equal(1, len(requests))
+	ass.Equal(strings.Join([]string{
+		`{"index":{"_id":"1","_index":"lo-index-lo","pipeline":"lo"}}`,
+		`{"title":"Go","tags":["go"]}`,
+		`{"update":{"_id":"2","_index":"lo-index-lo"}}`,
+		`{"doc":{"title":"Rust"},"doc_as_upsert":true}`,
+		`{"index":{"_id":"3","_index":"lo-index-lo","pipeline":"lo"}}`,
+		`{"title":1}`,
+		`{"delete":{"_id":"5","_index":"lo-index-lo"}}`,
+		``,
+	}, "\n"), requests[0])
+
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Equal(3, len(batchErr.Errs))
+
+	// too many requests is retried
+	_, ok = batchErr.Errs[1].(*TargetError)
+	ass.False(ok)
+
+	// mapping errors & invalid messages are dead-lettered
+	for _, i := range []int{2, 3} {
+		targetErr, ok := batchErr.Errs[i].(*TargetError)
+		ass.True(ok)
+		ass.Equal(ActionDeadLetter, targetErr.Action)
+	}
+}
+
+func TestElasticsearchTargetFailure(t *testing.T) {
+	ass := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	target, err := NewElasticsearchTarget("lo-index", &ElasticsearchTargetConfig{Url: server.URL, Index: "lo"}, http.DefaultClient)
+	ass.NoError(err)
+
+	// whole request failed, retry all messages
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.create", Body: []byte(`{"id": 1}`)})
+	ass.Error(err)
+	_, ok := err.(*BatchError)
+	ass.False(ok)
+
+	_, err = NewElasticsearchTarget("lo-index", &ElasticsearchTargetConfig{
+		Url:     server.URL,
+		Index:   "lo",
+		Actions: []ElasticsearchActionConfig{{RoutingKey: "lo.delete", Action: "delete"}},
+	}, http.DefaultClient)
+	ass.Error(err)
+}
+
+func TestElasticsearchTargetInvalidDocument(t *testing.T) {
+	ass := assert.New(t)
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+
+		w.Write([]byte(`{"errors": true, "items": [
+			{"index": {"_id": "1", "status": 201}},
+			{"index": {"_id": "3", "status": 400, "error": {"type": "mapper_parsing_exception"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	target, err := NewElasticsearchTarget("lo-index", &ElasticsearchTargetConfig{
+		Url:    server.URL,
+		Index:  "lo",
+		Id:     "body:id",
+		Source: "lo",
+	}, http.DefaultClient)
+	ass.NoError(err)
+
+	// 2nd document is invalid after its action line is known
+	_, err = target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		{RoutingKey: "lo.create", Body: []byte(`{"id": 1, "lo": {"title": "Go"}}`)},
+		{RoutingKey: "lo.create", Body: []byte(`{"id": 2, "lo": "not an object"}`)},
+		{RoutingKey: "lo.create", Body: []byte(`{"id": 3, "lo": {"title": 1}}`)},
+	})
+
+	ass.Equal(1, len(requests))
+	ass.Equal(strings.Join([]string{
+		`{"index":{"_id":"1","_index":"lo"}}`,
+		`{"title":"Go"}`,
+		`{"index":{"_id":"3","_index":"lo"}}`,
+		`{"title":1}`,
+		``,
+	}, "\n"), requests[0])
+
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Equal(2, len(batchErr.Errs))
+	ass.Contains(batchErr.Errs[1].Error(), "document not found")
+	ass.Contains(batchErr.Errs[2].Error(), "mapper_parsing_exception")
+}
@@ -0,0 +1,363 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+type FileTargetConfig struct {
+	Dir          string        `yaml:"dir"`
+	Filename     string        `yaml:"filename"`      // placeholders: %service%, %date% (2006-01-02). Default: %service%-%date%.jsonl
+	MaxSize      int64         `yaml:"max-size"`      // rotate when file reaches N bytes, 0 to disable
+	Interval     time.Duration `yaml:"interval"`      // rotate when file is opened for longer than, 0 to disable
+	Compress     bool          `yaml:"compress"`      // gzip closed files
+	Sync         string        `yaml:"sync"`          // always: fsync every message, interval (default), never: left to the OS
+	SyncInterval time.Duration `yaml:"sync-interval"` // Default: 1s
+
+	mu     sync.Mutex
+	refs   int
+	writer *fileWriter
+}
+
+// Line written for each message.
+type FileRecord struct {
+	RoutingKey string     `json:"routingKey"`
+	Headers    amqp.Table `json:"headers"`
+	Body       string     `json:"body"`
+	Encoding   string     `json:"encoding,omitempty"` // base64 if body is not valid UTF-8
+	Timestamp  time.Time  `json:"timestamp"`          // time of receiving the message
+}
+
+type FileTarget struct {
+	cnf     *FileTargetConfig
+	service string
+	writer  *fileWriter
+}
+
+func NewFileTarget(service string, cnf *FileTargetConfig) (Target, error) {
+	if nil == cnf || "" == cnf.Dir {
+		return nil, errors.New("missing file target dir")
+	}
+
+	if "" == cnf.Filename {
+		cnf.Filename = "%service%-%date%.jsonl"
+	}
+
+	if "" == cnf.Sync {
+		cnf.Sync = "interval"
+	}
+
+	if 0 == cnf.SyncInterval {
+		cnf.SyncInterval = time.Second
+	}
+
+	switch cnf.Sync {
+	case "always", "interval", "never":
+	default:
+		return nil, fmt.Errorf("unsupported file sync policy: %s", cnf.Sync)
+	}
+
+	return &FileTarget{cnf: cnf, service: service}, nil
+}
+
+// Workers of a service share the same writer, so that they never write to the same file concurrently.
+func (t *FileTarget) start() error {
+	t.cnf.mu.Lock()
+	defer t.cnf.mu.Unlock()
+
+	if nil == t.cnf.writer {
+		if err := os.MkdirAll(t.cnf.Dir, 0755); nil != err {
+			return err
+		}
+
+		t.cnf.writer = newFileWriter(t.cnf, t.service)
+	}
+
+	t.cnf.refs++
+	t.writer = t.cnf.writer
+
+	return nil
+}
+
+func (t *FileTarget) terminate() error {
+	t.cnf.mu.Lock()
+	defer t.cnf.mu.Unlock()
+
+	if nil == t.writer {
+		return nil
+	}
+
+	t.writer = nil
+	t.cnf.refs--
+	if t.cnf.refs > 0 {
+		return nil
+	}
+
+	writer := t.cnf.writer
+	t.cnf.writer = nil
+
+	return writer.close()
+}
+
+func (t *FileTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	record := FileRecord{
+		RoutingKey: m.RoutingKey,
+		Headers:    m.Headers,
+		Body:       string(m.Body),
+		Timestamp:  time.Now().UTC(),
+	}
+
+	if !utf8.Valid(m.Body) {
+		record.Body = base64.StdEncoding.EncodeToString(m.Body)
+		record.Encoding = "base64"
+	}
+
+	line, err := json.Marshal(record)
+	if nil != err {
+		return nil, err
+	}
+
+	if err := t.writer.write(append(line, '\n')); nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-file").
+			WithField("dir", t.cnf.Dir).
+			Error("failed writing")
+
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+type fileWriter struct {
+	cnf     *FileTargetConfig
+	service string
+
+	mu       sync.Mutex
+	file     *os.File
+	name     string // rendered filename, before de-duplicating
+	size     int64
+	openedAt time.Time
+	dirty    bool
+	done     chan bool
+
+	compressing sync.WaitGroup // rotated files are compressed without blocking writes
+}
+
+func newFileWriter(cnf *FileTargetConfig, service string) *fileWriter {
+	w := &fileWriter{cnf: cnf, service: service, done: make(chan bool)}
+
+	if "interval" == cnf.Sync {
+		go w.syncLoop()
+	}
+
+	return w
+}
+
+func (w *fileWriter) syncLoop() {
+	ticker := time.NewTicker(w.cnf.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case <-ticker.C:
+			w.mu.Lock()
+			if nil != w.file && w.dirty {
+				if err := w.file.Sync(); nil != err {
+					logrus.WithError(err).WithField("component", "target-file").Error("failed syncing")
+				}
+
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *fileWriter) write(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if nil != w.file && w.rotate(now) {
+		if err := w.closeFile(); nil != err {
+			return err
+		}
+	}
+
+	if nil == w.file {
+		if err := w.open(now); nil != err {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	w.dirty = true
+	if nil != err {
+		return err
+	}
+
+	if "always" == w.cnf.Sync {
+		w.dirty = false
+
+		return w.file.Sync()
+	}
+
+	return nil
+}
+
+func (w *fileWriter) rotate(now time.Time) bool {
+	if w.cnf.MaxSize > 0 && w.size >= w.cnf.MaxSize {
+		return true
+	}
+
+	if w.cnf.Interval > 0 && now.Sub(w.openedAt) >= w.cnf.Interval {
+		return true
+	}
+
+	return w.name != w.filename(now)
+}
+
+func (w *fileWriter) filename(now time.Time) string {
+	name := strings.Replace(w.cnf.Filename, "%service%", w.service, -1)
+
+	return strings.Replace(name, "%date%", now.UTC().Format("2006-01-02"), -1)
+}
+
+// Never append to existing files, ex: after restarting; next free name is name-1.jsonl, name-2.jsonl, …
+func (w *fileWriter) open(now time.Time) error {
+	name := w.filename(now)
+	ext := filepath.Ext(name)
+	path := filepath.Join(w.cnf.Dir, name)
+
+	for i := 1; fileExists(path) || fileExists(path+".gz"); i++ {
+		path = filepath.Join(w.cnf.Dir, strings.TrimSuffix(name, ext)+"-"+strconv.Itoa(i)+ext)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if nil != err {
+		return err
+	}
+
+	w.file = file
+	w.name = name
+	w.size = 0
+	w.openedAt = now
+
+	return nil
+}
+
+func (w *fileWriter) closeFile() error {
+	path := w.file.Name()
+	if "never" != w.cnf.Sync {
+		if err := w.file.Sync(); nil != err {
+			return err
+		}
+	}
+
+	if err := w.file.Close(); nil != err {
+		return err
+	}
+
+	w.file = nil
+	w.dirty = false
+
+	if w.cnf.Compress {
+		w.compressing.Add(1)
+		go w.compress(path)
+	}
+
+	return nil
+}
+
+func (w *fileWriter) compress(path string) {
+	defer w.compressing.Done()
+
+	if err := compressFile(path); nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-file").
+			WithField("path", path).
+			Error("failed compressing")
+	}
+}
+
+// Close the current file, wait for rotated files to be compressed.
+func (w *fileWriter) close() error {
+	close(w.done)
+
+	err := func() error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if nil == w.file {
+			return nil
+		}
+
+		return w.closeFile()
+	}()
+
+	w.compressing.Wait()
+
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return nil == err
+}
+
+// Replace the file by its gzipped version.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if nil != err {
+		return err
+	}
+
+	writer := gzip.NewWriter(out)
+	if _, err = io.Copy(writer, in); nil == err {
+		err = writer.Close()
+	}
+
+	if nil == err {
+		err = out.Sync()
+	}
+
+	if closeErr := out.Close(); nil == err {
+		err = closeErr
+	}
+
+	if nil != err {
+		_ = os.Remove(path + ".gz")
+
+		return err
+	}
+
+	return os.Remove(path)
+}
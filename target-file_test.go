@@ -0,0 +1,83 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTargetRotation(t *testing.T) {
+	ass := assert.New(t)
+	cnf := &FileTargetConfig{Dir: t.TempDir(), MaxSize: 1, Compress: true, Sync: "always"}
+
+	// two workers of the same service
+	workers := []Target{}
+	for i := 0; i < 2; i++ {
+		target, err := NewFileTarget("lo-index", cnf)
+		ass.NoError(err)
+		ass.NoError(target.start())
+		workers = append(workers, target)
+	}
+
+	for i, body := range []string{`{"id": 1}`, `{"id": 2}`, "\xff\xfe"} {
+		_, err := workers[i%2].handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(body), Headers: amqp.Table{"X-VERSION": "v1"}})
+		ass.NoError(err)
+	}
+
+	for _, target := range workers {
+		ass.NoError(target.terminate())
+	}
+
+	date := time.Now().UTC().Format("2006-01-02")
+	names := []string{}
+	files, _ := ioutil.ReadDir(cnf.Dir)
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	ass.Equal([]string{
+		"lo-index-" + date + "-1.jsonl.gz",
+		"lo-index-" + date + "-2.jsonl.gz",
+		"lo-index-" + date + ".jsonl.gz",
+	}, names)
+
+	file, _ := os.Open(filepath.Join(cnf.Dir, "lo-index-"+date+"-2.jsonl.gz"))
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	ass.NoError(err)
+	raw, _ := ioutil.ReadAll(reader)
+
+	record := FileRecord{}
+	ass.NoError(json.Unmarshal([]byte(strings.TrimSpace(string(raw))), &record))
+	ass.Equal("lo.update", record.RoutingKey)
+	ass.Equal("base64", record.Encoding)
+	ass.Equal("//4=", record.Body)
+	ass.Equal(amqp.Table{"X-VERSION": "v1"}, record.Headers)
+}
+
+func TestFileTargetAppendsLines(t *testing.T) {
+	ass := assert.New(t)
+	cnf := &FileTargetConfig{Dir: t.TempDir(), Filename: "%service%.jsonl"}
+	target, _ := NewFileTarget("lo-index", cnf)
+	ass.NoError(target.start())
+
+	for _, body := range []string{`{"id": 1}`, `{"id": 2}`} {
+		_, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(body)})
+		ass.NoError(err)
+	}
+	ass.NoError(target.terminate())
+
+	raw, err := ioutil.ReadFile(filepath.Join(cnf.Dir, "lo-index.jsonl"))
+	ass.NoError(err)
+
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	ass.Len(lines, 2)
+	ass.Contains(lines[1], `"body":"{\"id\": 2}"`)
+}
@@ -0,0 +1,277 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type GrpcTargetConfig struct {
+	Address string            `yaml:"address"` // host:port
+	Method  string            `yaml:"method"`  // full method name, ex: /go1.consumer.Consumer/Consume
+	Timeout time.Duration     `yaml:"timeout"` // deadline of each call. Default: 30s
+	Tls     *TlsConfig        `yaml:"tls"`     // plaintext if not configured
+	Codes   map[string]string `yaml:"codes"`   // status code (NOT_FOUND, UNAVAILABLE, …) -> ack, retry, dead-letter, drop
+
+	actions map[codes.Code]string
+}
+
+// Status codes which are not retried unless configured, invalid & forbidden messages are dead-lettered.
+var defaultGrpcActions = map[codes.Code]string{
+	codes.OK:               ActionAck,
+	codes.InvalidArgument:  ActionDeadLetter,
+	codes.PermissionDenied: ActionDeadLetter,
+}
+
+func (c *GrpcTargetConfig) onParse() error {
+	if 0 == c.Timeout {
+		c.Timeout = 30 * time.Second
+	}
+
+	c.actions = map[codes.Code]string{}
+	for code, action := range defaultGrpcActions {
+		c.actions[code] = action
+	}
+
+	for name, action := range c.Codes {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); nil != err {
+			return err
+		}
+
+		switch action {
+		case ActionAck, ActionRetry, ActionDeadLetter, ActionDrop:
+			c.actions[code] = action
+
+		default:
+			return fmt.Errorf("invalid action for code %s: %s", name, action)
+		}
+	}
+
+	return nil
+}
+
+func (c *GrpcTargetConfig) action(code codes.Code) string {
+	if action, ok := c.actions[code]; ok {
+		return action
+	}
+
+	return ActionRetry
+}
+
+type GrpcTarget struct {
+	cnf  *GrpcTargetConfig
+	conn *grpc.ClientConn
+}
+
+func NewGrpcTarget(cnf *GrpcTargetConfig) (Target, error) {
+	if nil == cnf {
+		return nil, fmt.Errorf("missing grpc target configuration")
+	}
+
+	if err := cnf.onParse(); nil != err {
+		return nil, err
+	}
+
+	return &GrpcTarget{cnf: cnf}, nil
+}
+
+func (t *GrpcTarget) start() error {
+	creds := insecure.NewCredentials()
+	if nil != t.cnf.Tls {
+		tlsConfig, err := t.cnf.Tls.config()
+		if nil != err {
+			return err
+		}
+
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(t.cnf.Address, grpc.WithTransportCredentials(creds))
+	if nil != err {
+		return err
+	}
+
+	t.conn = conn
+
+	return nil
+}
+
+func (t *GrpcTarget) terminate() error {
+	if nil != t.conn {
+		return t.conn.Close()
+	}
+
+	return nil
+}
+
+func (t *GrpcTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.cnf.Timeout)
+	defer cancel()
+
+	ctx = metadata.NewOutgoingContext(ctx, grpcMetadata(m.Headers))
+
+	var response []byte
+	err := t.conn.Invoke(ctx, t.cnf.Method, grpcConsumeRequest(m), &response, grpc.ForceCodec(grpcRawCodec{}))
+	code := status.Code(err)
+
+	switch t.cnf.action(code) {
+	case ActionAck:
+		if nil != err {
+			logrus.
+				WithError(err).
+				WithField("component", "target-grpc").
+				WithField("method", t.cnf.Method).
+				WithField("code", code.String()).
+				Warning("failed invoking, message is acked as configured")
+
+			return nil, nil
+		}
+
+		return grpcConsumeResponse(response), nil
+
+	case ActionRetry:
+		logrus.
+			WithError(err).
+			WithField("component", "target-grpc").
+			WithField("method", t.cnf.Method).
+			WithField("code", code.String()).
+			Error("failed invoking")
+
+		return nil, err
+
+	default:
+		return nil, &TargetError{Action: t.cnf.action(code), Err: err}
+	}
+}
+
+// Headers with string values are sent as metadata.
+func grpcMetadata(headers amqp.Table) metadata.MD {
+	md := metadata.MD{}
+	for key, value := range headers {
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+
+		if stringValue, ok := value.(string); ok {
+			md.Append(key, stringValue)
+		}
+	}
+
+	return md
+}
+
+// Encode the generic consume request, see resources/consume.proto
+//
+//	message ConsumeRequest {
+//	  string              routing_key = 1;
+//	  bytes               body        = 2;
+//	  map<string, string> headers     = 3;
+//	}
+func grpcConsumeRequest(m *amqp.Delivery) []byte {
+	var out []byte
+	out = protowire.AppendTag(out, 1, protowire.BytesType)
+	out = protowire.AppendString(out, m.RoutingKey)
+	out = protowire.AppendTag(out, 2, protowire.BytesType)
+	out = protowire.AppendBytes(out, m.Body)
+
+	keys := []string{}
+	for key := range m.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := m.Headers[key].(string)
+		if !ok {
+			raw, _ := json.Marshal(m.Headers[key])
+			value = string(raw)
+		}
+
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, value)
+
+		out = protowire.AppendTag(out, 3, protowire.BytesType)
+		out = protowire.AppendBytes(out, entry)
+	}
+
+	return out
+}
+
+// Decode body of the consume response, returned to the pipeline.
+//
+//	message ConsumeResponse {
+//	  bytes body = 1;
+//	}
+func grpcConsumeResponse(response []byte) []byte {
+	for len(response) > 0 {
+		num, typ, n := protowire.ConsumeTag(response)
+		if n < 0 {
+			return nil
+		}
+		response = response[n:]
+
+		if 1 == num && protowire.BytesType == typ {
+			body, n := protowire.ConsumeBytes(response)
+			if n < 0 || 0 == len(body) {
+				return nil
+			}
+
+			return body
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, response)
+		if n < 0 {
+			return nil
+		}
+		response = response[n:]
+	}
+
+	return nil
+}
+
+// Codec passing already encoded messages through, so that no generated code is needed.
+type grpcRawCodec struct{}
+
+func (grpcRawCodec) Name() string { return "proto" }
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch msg := v.(type) {
+	case []byte:
+		return msg, nil
+
+	case *[]byte:
+		return *msg, nil
+	}
+
+	return nil, fmt.Errorf("unsupported message type: %T", v)
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unsupported message type: %T", v)
+	}
+
+	*msg = append([]byte{}, data...)
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestGrpcTarget(t *testing.T) {
+	ass := assert.New(t)
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	requests := [][]byte{}
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(grpcRawCodec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			var req []byte
+			if err := stream.RecvMsg(&req); nil != err {
+				return err
+			}
+			requests = append(requests, req)
+
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			switch md.Get("x-version")[0] {
+			case "v2":
+				return status.Error(codes.NotFound, "no such user")
+			case "v3":
+				return status.Error(codes.Unavailable, "try later")
+			case "v4":
+				return status.Error(codes.InvalidArgument, "invalid user")
+			}
+
+			var res []byte
+			res = protowire.AppendTag(res, 1, protowire.BytesType)
+			res = protowire.AppendString(res, `{"type": "publish.message"}`)
+
+			return stream.SendMsg(res)
+		}),
+	)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	target, err := NewGrpcTarget(&GrpcTargetConfig{
+		Address: listener.Addr().String(),
+		Method:  "/go1.consumer.Consumer/Consume",
+		Codes:   map[string]string{"NOT_FOUND": ActionDrop},
+	})
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	m := &amqp.Delivery{RoutingKey: "user.update", Body: []byte(`{"id": 1}`), Headers: amqp.Table{"X-VERSION": "v1"}}
+	response, err := target.handle(m)
+	ass.NoError(err)
+	ass.Equal(`{"type": "publish.message"}`, string(response))
+	ass.Equal(grpcConsumeRequest(m), requests[0])
+
+	m.Headers["X-VERSION"] = "v2"
+	_, err = target.handle(m)
+	ass.Equal(ActionDrop, err.(*TargetError).Action)
+
+	m.Headers["X-VERSION"] = "v3"
+	_, err = target.handle(m)
+	ass.Equal(codes.Unavailable, status.Code(err))
+
+	m.Headers["X-VERSION"] = "v4"
+	_, err = target.handle(m)
+	ass.Equal(ActionDeadLetter, err.(*TargetError).Action, "invalid messages are dead-lettered by default")
+}
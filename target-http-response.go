@@ -0,0 +1,130 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Map response status codes to the action applied to the message.
+type ResponseRule struct {
+	Status string `yaml:"status"` // 204, 4xx, 500-503
+	Action string `yaml:"action"` // ack, retry, dead-letter, drop
+
+	min int
+	max int
+}
+
+// Rules used when no rule matched, or no rule is configured.
+var defaultResponseRules = []ResponseRule{
+	{Status: "200", Action: ActionAck, min: 200, max: 200},
+	{Status: "204", Action: ActionAck, min: 204, max: 204},
+	{Status: "400", Action: ActionAck, min: 400, max: 400},
+	{Status: "403", Action: ActionAck, min: 403, max: 403},
+	{Status: "413", Action: ActionAck, min: 413, max: 413},
+}
+
+func (r *ResponseRule) onParse() error {
+	status := strings.TrimSpace(r.Status)
+
+	switch {
+	case 3 == len(status) && strings.HasSuffix(strings.ToLower(status), "xx"):
+		class, err := strconv.Atoi(status[0:1])
+		if nil != err {
+			return fmt.Errorf("invalid status: %s", r.Status)
+		}
+
+		r.min, r.max = class*100, class*100+99
+
+	case strings.Contains(status, "-"):
+		bounds := strings.SplitN(status, "-", 2)
+		min, err1 := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		max, err2 := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if nil != err1 || nil != err2 {
+			return fmt.Errorf("invalid status: %s", r.Status)
+		}
+
+		r.min, r.max = min, max
+
+	default:
+		code, err := strconv.Atoi(status)
+		if nil != err {
+			return fmt.Errorf("invalid status: %s", r.Status)
+		}
+
+		r.min, r.max = code, code
+	}
+
+	switch r.Action {
+	case ActionAck, ActionRetry, ActionDeadLetter, ActionDrop:
+		return nil
+	}
+
+	return fmt.Errorf("invalid action for status %s: %s", r.Status, r.Action)
+}
+
+func (r ResponseRule) match(statusCode int) bool {
+	return r.min <= statusCode && statusCode <= r.max
+}
+
+func responseAction(rules []ResponseRule, statusCode int) string {
+	for _, rule := range rules {
+		if rule.match(statusCode) {
+			return rule.Action
+		}
+	}
+
+	for _, rule := range defaultResponseRules {
+		if rule.match(statusCode) {
+			return rule.Action
+		}
+	}
+
+	return ActionRetry
+}
+
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// Convert the response to error of the action to be applied on the message, nil on ack.
+func responseError(rules []ResponseRule, res *http.Response) error {
+	action := responseAction(rules, res.StatusCode)
+	if ActionAck == action {
+		return nil
+	}
+
+	err := &TargetError{
+		Action: action,
+		Err:    fmt.Errorf("service responded status %d", res.StatusCode),
+	}
+
+	if ActionRetry == action {
+		if http.StatusTooManyRequests == res.StatusCode || http.StatusServiceUnavailable == res.StatusCode {
+			err.RetryAfter = retryAfter(res.Header.Get("Retry-After"))
+		}
+	}
+
+	return err
+}
+
+// Retry-After is either number of seconds, or a HTTP date.
+func retryAfter(value string) time.Duration {
+	if "" == value {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); nil == err {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); nil == err {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
 )
 
 type serviceLog struct {
@@ -236,3 +237,189 @@ func TestDistributedTracing(t *testing.T) {
 	ass.Equal("", log.Header.Get("X-Datadog-Sampling-Priority"), "should not have error if failed converting to string")
 	ass.Equal("xxxxx-4", log.Header.Get("X-Datadog-Origin"))
 }
+
+func TestResponseRules(t *testing.T) {
+	ass := assert.New(t)
+	cnf := &HttpTargetConfig{
+		Responses: []ResponseRule{
+			{Status: "409", Action: ActionDrop},
+			{Status: "4xx", Action: ActionDeadLetter},
+			{Status: "500-502", Action: ActionRetry},
+		},
+	}
+	cnf.onParse(&HttpClientConfig{})
+
+	ass.Equal(ActionDrop, responseAction(cnf.Responses, 409))
+	ass.Equal(ActionDeadLetter, responseAction(cnf.Responses, 404))
+	ass.Equal(ActionRetry, responseAction(cnf.Responses, 501))
+	ass.Equal(ActionAck, responseAction(cnf.Responses, 204), "fallback to default rules")
+	ass.Equal(ActionRetry, responseAction(cnf.Responses, 503))
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+	defer ts.Close()
+
+	payload := Payload{RoutingKey: "foo", Body: "foo", target: &HttpTargetConfig{Method: http.MethodPost}}
+	err := payload.push(http.DefaultClient, ts.URL)
+	targetErr, ok := err.(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionRetry, targetErr.Action)
+	ass.Equal(2*time.Minute, targetErr.RetryAfter)
+}
+
+func TestJsonResponseIsReturned(t *testing.T) {
+	ass := assert.New(t)
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if "json" == r.URL.Query().Get("format") {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.Write([]byte(`{"type": "publish.message", "subject": "user.login", "message": {"id": 1}}`))
+			} else {
+				w.Write([]byte(`OK`))
+			}
+		}))
+	defer ts.Close()
+
+	payload := Payload{RoutingKey: "foo", Body: "foo"}
+	response, err := payload.send(http.DefaultClient, ts.URL+"?format=json")
+	ass.NoError(err)
+	ass.Equal("publish.message", gjson.GetBytes(response, "type").String())
+
+	response, err = payload.send(http.DefaultClient, ts.URL)
+	ass.NoError(err)
+	ass.Nil(response)
+}
+
+type httpRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func newHttpTargetServer(t *testing.T) (*httptest.Server, chan httpRequest) {
+	requests := make(chan httpRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- httpRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// App config is replaced while the test runs.
+func withAppConfig(t *testing.T, yaml string) *AppConfig {
+	app = NewApp(make(chan bool))
+	config := app.config
+	app.config, _ = NewAppConfig([]byte(yaml))
+	t.Cleanup(func() { app.config = config })
+
+	return app.config
+}
+
+func TestHttpTargetConfig(t *testing.T) {
+	ass := assert.New(t)
+	server, requests := newHttpTargetServer(t)
+	cnf := withAppConfig(t, `
+http-client:
+  service-url-pattern: "http://127.0.0.1:1/SERVICE"
+services:
+  - name: user-service
+    target:
+      http:
+        method: PUT
+        headers:
+          X-Api-Key: secret
+  - name: raw-service
+    target:
+      http:
+        url: "`+server.URL+`/raw/SERVICE"
+        format: raw
+        timeout-request: 1s
+`)
+
+	// global pattern changed after parsing config
+	cnf.HttpClient.SetServiceUrlPattern(server.URL + "/SERVICE")
+
+	target, err := NewHttpTarget(&cnf.Services[0], cnf.HttpClient.Get())
+	ass.NoError(err)
+	ass.NoError(target.start())
+	ass.Same(cnf.HttpClient.Get(), target.(*HttpTarget).client, "global client is shared")
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "user.create", Body: []byte(`{"id": 1}`)})
+	ass.NoError(err)
+
+	req := <-requests
+	ass.Equal(http.MethodPut, req.method)
+	ass.Equal("/user-service", req.path)
+	ass.Equal("secret", req.header.Get("X-Api-Key"))
+	ass.Equal("application/json", req.header.Get("Content-Type"))
+	ass.Equal("user.create", gjson.Get(req.body, "routingKey").String())
+	ass.Equal(`{"id": 1}`, gjson.Get(req.body, "body").String())
+
+	target, err = NewHttpTarget(&cnf.Services[1], cnf.HttpClient.Get())
+	ass.NoError(err)
+	ass.NoError(target.start())
+
+	client := target.(*HttpTarget).client
+	ass.NotSame(cnf.HttpClient.Get(), client, "custom timeouts use their own client")
+	ass.Equal(time.Second, client.Timeout)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "user.create", ContentType: "text/plain", Body: []byte("user 1")})
+	ass.NoError(err)
+
+	req = <-requests
+	ass.Equal(http.MethodPost, req.method)
+	ass.Equal("/raw/raw-service", req.path)
+	ass.Equal("text/plain", req.header.Get("Content-Type"))
+	ass.Equal("user.create", req.header.Get("X-Routing-Key"))
+	ass.Equal("user 1", req.body)
+	ass.Equal(server.URL+"/raw/SERVICE", cnf.Services[1].Target.Http.Url, "config is left untouched")
+	ass.Equal("", cnf.Services[0].Target.Http.Url)
+}
+
+func TestHttpTargetBatchHeaders(t *testing.T) {
+	ass := assert.New(t)
+	server, requests := newHttpTargetServer(t)
+	cnf := withAppConfig(t, `
+services:
+  - name: batch-service
+    batch: { size: 2 }
+    target:
+      http:
+        url: "`+server.URL+`/SERVICE"
+        headers:
+          X-Api-Key: secret
+`)
+
+	target, err := NewHttpTarget(&cnf.Services[0], cnf.HttpClient.Get())
+	ass.NoError(err)
+	ass.NoError(target.start())
+
+	_, err = target.(*HttpTarget).handleBatch([]*amqp.Delivery{
+		{RoutingKey: "user.create", Body: []byte(`{"id": 1}`), Headers: amqp.Table{"request_id": "req-1", "x-datadog-trace-id": "trace-1"}},
+		{RoutingKey: "user.update", Body: []byte(`{"id": 2}`), Headers: amqp.Table{"request_id": "req-2"}},
+	})
+	ass.NoError(err)
+
+	req := <-requests
+	ass.Equal("/batch-service", req.path)
+	ass.Equal("secret", req.header.Get("X-Api-Key"))
+	ass.Equal("go1.consumer/"+app.version, req.header.Get("User-Agent"))
+	ass.Equal("req-1", req.header.Get("X-Request-Id"), "request headers of the first message")
+	ass.Equal("trace-1", req.header.Get("X-Datadog-Trace-Id"))
+	ass.Equal("user.update", gjson.Get(req.body, "1.routingKey").String())
+
+	ass.Panics(func() {
+		NewAppConfig([]byte(`
+services:
+  - name: raw-service
+    batch: { size: 2 }
+    target: { http: { format: raw } }
+`))
+	}, "routing keys of raw bodies would be lost in batches")
+}
@@ -0,0 +1,91 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Kafka target confirming messages asynchronously, so that many messages are sent in batches.
+type KafkaAsyncTarget struct {
+	target   *KafkaTarget
+	producer sarama.AsyncProducer
+	done     sync.WaitGroup
+}
+
+func newKafkaAsyncTarget(target *KafkaTarget, producer sarama.AsyncProducer) *KafkaAsyncTarget {
+	return &KafkaAsyncTarget{target: target, producer: producer}
+}
+
+func (t *KafkaAsyncTarget) start() error {
+	t.done.Add(2)
+
+	go func() {
+		defer t.done.Done()
+
+		for msg := range t.producer.Successes() {
+			msg.Metadata.(func(error))(nil)
+		}
+	}()
+
+	go func() {
+		defer t.done.Done()
+
+		for err := range t.producer.Errors() {
+			logrus.
+				WithError(err.Err).
+				WithField("component", "target-kafka").
+				WithField("topic", err.Msg.Topic).
+				Error("failed pushing")
+
+			err.Msg.Metadata.(func(error))(err.Err)
+		}
+	}()
+
+	return nil
+}
+
+// Flush buffered messages, callbacks of all messages are called before returning.
+func (t *KafkaAsyncTarget) terminate() error {
+	t.producer.AsyncClose()
+	t.done.Wait()
+
+	return nil
+}
+
+func (t *KafkaAsyncTarget) handleAsync(m *amqp.Delivery, done func(err error)) {
+	msg, err := t.target.message(m)
+	if nil != err {
+		done(err)
+
+		return
+	}
+
+	msg.Metadata = done
+	t.producer.Input() <- msg
+}
+
+func (t *KafkaAsyncTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	result := make(chan error, 1)
+	t.handleAsync(m, func(err error) { result <- err })
+
+	return nil, <-result
+}
+
+func (t *KafkaAsyncTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	results := make(chan error, len(ms))
+	for _, m := range ms {
+		t.handleAsync(m, func(err error) { results <- err })
+	}
+
+	var err error
+	for range ms {
+		if result := <-results; nil != result && nil == err {
+			err = result
+		}
+	}
+
+	return nil, err
+}
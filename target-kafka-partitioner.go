@@ -0,0 +1,91 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+func kafkaPartitioner(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case "", "hash":
+		return sarama.NewHashPartitioner, nil
+
+	case "murmur2":
+		return newMurmur2Partitioner, nil
+
+	case "random":
+		return sarama.NewRandomPartitioner, nil
+
+	case "manual":
+		return sarama.NewManualPartitioner, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported kafka partitioner: %s", name)
+	}
+}
+
+// Same partitions as the default partitioner of the Java client, so that keys
+// are co-located with messages produced by other services.
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if nil == message.Key {
+		return p.random.Partition(message, numPartitions)
+	}
+
+	key, err := message.Key.Encode()
+	if nil != err {
+		return -1, err
+	}
+
+	return (murmur2(key) & 0x7fffffff) % numPartitions, nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool { return true }
+
+// Port of org.apache.kafka.common.utils.Utils.murmur2
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
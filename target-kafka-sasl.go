@@ -0,0 +1,67 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+type KafkaSaslConfig struct {
+	Mechanism string  `yaml:"mechanism"` // PLAIN (default), SCRAM-SHA-256, SCRAM-SHA-512
+	Username  string  `yaml:"username"`
+	Password  *Secret `yaml:"password"`
+}
+
+func (s *KafkaSaslConfig) apply(c *sarama.Config) error {
+	password, err := s.Password.value()
+	if nil != err {
+		return err
+	}
+
+	c.Net.SASL.Enable = true
+	c.Net.SASL.User = s.Username
+	c.Net.SASL.Password = password
+
+	switch s.Mechanism {
+	case "", sarama.SASLTypePlaintext:
+		c.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+
+	case sarama.SASLTypeSCRAMSHA256:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &kafkaScramClient{hash: scram.SHA256} }
+
+	case sarama.SASLTypeSCRAMSHA512:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &kafkaScramClient{hash: scram.SHA512} }
+
+	default:
+		return fmt.Errorf("unsupported kafka sasl mechanism: %s", s.Mechanism)
+	}
+
+	return nil
+}
+
+type kafkaScramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *kafkaScramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if nil != err {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *kafkaScramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *kafkaScramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -2,6 +2,8 @@ package rabbitmq_consumer_bridge
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -16,35 +18,149 @@ type KafkaConnectionOptions struct {
 	AckReplicas sarama.RequiredAcks     `yaml:"ack"`
 	Compress    sarama.CompressionCodec `yaml:"compress"`
 	Retry       int                     `yaml:"retry"`
+	Linger      time.Duration           `yaml:"linger"`      // max time messages wait to be sent in a batch, 0: as fast as possible
+	BatchSize   int                     `yaml:"batch-size"`  // number of messages triggering a flush
+	BatchBytes  int                     `yaml:"batch-bytes"` // bytes triggering a flush
+	Idempotent  bool                    `yaml:"idempotent"`  // no duplicates on retries, forces ack: -1 & one in-flight request per broker
+	Version     string                  `yaml:"version"`     // Kafka protocol version, ex: 2.1.0
+	Tls         *TlsConfig              `yaml:"tls"`
+	Sasl        *KafkaSaslConfig        `yaml:"sasl"`
+}
+
+func (o *KafkaConnectionOptions) config(partitioner sarama.PartitionerConstructor) (*sarama.Config, error) {
+	c := sarama.NewConfig()
+	c.Producer.Partitioner = partitioner
+	c.Producer.RequiredAcks = o.AckReplicas // Wait for all in-sync replicas to ack the message
+	c.Producer.Compression = o.Compress
+	c.Producer.Retry.Max = o.Retry
+	c.Producer.Return.Successes = true
+	c.Producer.Flush.Frequency = o.Linger
+	c.Producer.Flush.Messages = o.BatchSize
+	c.Producer.Flush.Bytes = o.BatchBytes
+
+	if "" != o.ClientID {
+		c.ClientID = o.ClientID
+	}
+
+	if 0 != o.Timeout {
+		c.Producer.Timeout = o.Timeout
+	}
+
+	if "" != o.Version {
+		version, err := sarama.ParseKafkaVersion(o.Version)
+		if nil != err {
+			return nil, err
+		}
+
+		c.Version = version
+	}
+
+	if nil != o.Tls {
+		tlsConfig, err := o.Tls.config()
+		if nil != err {
+			return nil, err
+		}
+
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = tlsConfig
+	}
+
+	if nil != o.Sasl {
+		if err := o.Sasl.apply(c); nil != err {
+			return nil, err
+		}
+	}
+
+	if o.Idempotent {
+		c.Producer.Idempotent = true
+		c.Producer.RequiredAcks = sarama.WaitForAll
+		c.Net.MaxOpenRequests = 1
+		if 0 == c.Producer.Retry.Max {
+			c.Producer.Retry.Max = 3
+		}
+
+		if !c.Version.IsAtLeast(sarama.V0_11_0_0) {
+			c.Version = sarama.V0_11_0_0
+		}
+	}
+
+	return c, c.Validate()
 }
 
 type KafkaServiceConfig struct {
-	Connection string `yaml:"connection"`
-	Topic      string `yaml:"topic"`
+	Connection  string `yaml:"connection"`
+	Topic       string `yaml:"topic"`       // placeholders: %routing-key%, %routing-key.N%, %service%
+	Key         string `yaml:"key"`         // routing-key, header:NAME, body:GJSON_PATH. Default: no key
+	Partitioner string `yaml:"partitioner"` // hash (default), murmur2 (same as Java client), random, manual
+	Partition   string `yaml:"partition"`   // manual partitioner: header:NAME, body:GJSON_PATH
+	Mode        string `yaml:"mode"`        // sync (default): one message at a time, async: messages are acked when Kafka confirms them
+	InFlight    int    `yaml:"in-flight"`   // async: max unacknowledged messages per worker. Default: 100
 }
 
 type KafkaTarget struct {
-	Client sarama.SyncProducer
-	Topic  string
+	Client   sarama.SyncProducer
+	Topic    string
+	cnf      *KafkaServiceConfig
+	service  string
+	encoding *EncodingConfig
 }
 
 func NewKafkaTarget(cnf *AppConfig, service *ServiceConfig) (Target, error) {
-	connections := *cnf.Kafka
+	if nil == cnf.Kafka {
+		return nil, errors.New("no kafka connection configured")
+	}
+
 	connection := service.Target.Kafka.Connection
 	if "" == service.Target.Kafka.Connection {
 		connection = "default"
 	}
 
-	o := connections[connection]
-	c := sarama.NewConfig()
-	c.ClientID = o.ClientID
-	c.Producer.RequiredAcks = o.AckReplicas // Wait for all in-sync replicas to ack the message
-	c.Producer.Timeout = o.Timeout
-	c.Producer.Compression = o.Compress
-	c.Producer.Retry.Max = o.Retry
-	producer, _ := sarama.NewSyncProducer(o.Servers, c)
+	o, ok := (*cnf.Kafka)[connection]
+	if !ok {
+		return nil, fmt.Errorf("kafka connection not found: %s", connection)
+	}
+
+	partitioner, err := kafkaPartitioner(service.Target.Kafka.Partitioner)
+	if nil != err {
+		return nil, err
+	}
+
+	if "manual" == service.Target.Kafka.Partitioner && "" == service.Target.Kafka.Partition {
+		return nil, errors.New("missing kafka partition of manual partitioner")
+	}
+
+	c, err := o.config(partitioner)
+	if nil != err {
+		return nil, err
+	}
+
+	t := &KafkaTarget{
+		Topic:    service.Target.Kafka.Topic,
+		cnf:      service.Target.Kafka,
+		service:  service.Name,
+		encoding: service.Target.Encoding,
+	}
 
-	return &KafkaTarget{Client: producer, Topic: service.Target.Kafka.Topic}, nil
+	switch service.Target.Kafka.Mode {
+	case "", "sync":
+		// fail at start if brokers are unreachable, instead of on first message
+		if t.Client, err = sarama.NewSyncProducer(o.Servers, c); nil != err {
+			return nil, fmt.Errorf("failed connecting kafka %s: %s", connection, err)
+		}
+
+		return t, nil
+
+	case "async":
+		producer, err := sarama.NewAsyncProducer(o.Servers, c)
+		if nil != err {
+			return nil, fmt.Errorf("failed connecting kafka %s: %s", connection, err)
+		}
+
+		return newKafkaAsyncTarget(t, producer), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported kafka mode: %s", service.Target.Kafka.Mode)
+	}
 }
 
 func (t *KafkaTarget) start() error { return nil }
@@ -54,24 +170,9 @@ func (t *KafkaTarget) terminate() error {
 }
 
 func (t *KafkaTarget) handle(m *amqp.Delivery) ([]byte, error) {
-	msg := &sarama.ProducerMessage{
-		Topic:     t.Topic,
-		Timestamp: time.Now(),
-		Value:     sarama.StringEncoder(m.Body),
-		Headers: func() []sarama.RecordHeader {
-			headers := []sarama.RecordHeader{}
-
-			if len(m.Headers) > 0 {
-				for key, val := range m.Headers {
-					headers = append(headers, sarama.RecordHeader{
-						Key:   []byte(key),
-						Value: val.([]byte),
-					})
-				}
-			}
-
-			return headers
-		}(),
+	msg, err := t.message(m)
+	if nil != err {
+		return nil, err
 	}
 
 	partition, offset, err := t.Client.SendMessage(msg)
@@ -95,3 +196,102 @@ func (t *KafkaTarget) handle(m *amqp.Delivery) ([]byte, error) {
 
 	return nil, nil
 }
+
+// Members without key or partition are reported on their own, the others are sent.
+func (t *KafkaTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	errs := map[int]error{}
+	msgs := []*sarama.ProducerMessage{}
+	for i, m := range ms {
+		msg, err := t.message(m)
+		if nil != err {
+			errs[i] = err
+			continue
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if 0 != len(msgs) {
+		if err := t.Client.SendMessages(msgs); nil != err {
+			logrus.
+				WithError(err).
+				WithField("component", "target-kafka").
+				WithField("batch.size", len(msgs)).
+				Error("failed pushing")
+
+			if 0 == len(errs) {
+				return nil, errors.New("failed pushing")
+			}
+
+			for i := range ms {
+				if _, ok := errs[i]; !ok {
+					errs[i] = errors.New("failed pushing")
+				}
+			}
+		}
+	}
+
+	if 0 != len(errs) {
+		return nil, &BatchError{Errs: errs}
+	}
+
+	return nil, nil
+}
+
+func (t *KafkaTarget) message(m *amqp.Delivery) (*sarama.ProducerMessage, error) {
+	body, contentEncoding, err := t.encoding.encode(m)
+	if nil != err {
+		return nil, err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     render(t.Topic, t.service, m),
+		Timestamp: time.Now(),
+		Value:     sarama.StringEncoder(body),
+		Headers:   kafkaHeaders(m.Headers),
+	}
+
+	if "" != contentEncoding {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{
+			Key:   []byte("content-encoding"),
+			Value: []byte(contentEncoding),
+		})
+	}
+
+	if "" != t.cnf.Key {
+		key, ok := selectValue(t.cnf.Key, m)
+		if !ok {
+			return nil, &TargetError{Action: ActionDeadLetter, Err: fmt.Errorf("kafka key not found: %s", t.cnf.Key)}
+		}
+
+		msg.Key = sarama.ByteEncoder(key)
+	}
+
+	if "manual" == t.cnf.Partitioner {
+		value, ok := selectValue(t.cnf.Partition, m)
+		if !ok {
+			return nil, &TargetError{Action: ActionDeadLetter, Err: fmt.Errorf("kafka partition not found: %s", t.cnf.Partition)}
+		}
+
+		partition, err := strconv.ParseInt(string(value), 10, 32)
+		if nil != err {
+			return nil, &TargetError{Action: ActionDeadLetter, Err: fmt.Errorf("invalid kafka partition %s: %s", t.cnf.Partition, err)}
+		}
+
+		msg.Partition = int32(partition)
+	}
+
+	return msg, nil
+}
+
+func kafkaHeaders(table amqp.Table) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{}
+	for key, value := range table {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(key),
+			Value: headerValue(value),
+		})
+	}
+
+	return headers
+}
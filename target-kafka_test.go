@@ -0,0 +1,206 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+func TestKafkaMessage(t *testing.T) {
+	ass := assert.New(t)
+	target := &KafkaTarget{
+		Topic:   "%service%.%routing-key.0%",
+		service: "core",
+		cnf:     &KafkaServiceConfig{Key: "body:portal.id", Partitioner: "manual", Partition: "header:X-PARTITION"},
+	}
+
+	msg, err := target.message(&amqp.Delivery{
+		RoutingKey: "lo.update",
+		Body:       []byte(`{"id": 1, "portal": {"id": 555}}`),
+		Headers: amqp.Table{
+			"X-PARTITION": int32(3),
+			"X-VERSION":   "v1",
+			"X-RAW":       []byte("raw"),
+			"X-DELETED":   false,
+			"X-PRICE":     amqp.Decimal{Scale: 2, Value: 1050},
+			"X-TIME":      time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC),
+			"X-CONTEXT":   amqp.Table{"actor": int64(1)},
+			"X-NIL":       nil,
+		},
+	})
+	ass.NoError(err)
+	ass.Equal("core.lo", msg.Topic)
+	ass.Equal(sarama.ByteEncoder("555"), msg.Key)
+	ass.Equal(int32(3), msg.Partition)
+
+	headers := map[string]string{}
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	ass.Equal(map[string]string{
+		"X-PARTITION": "3",
+		"X-VERSION":   "v1",
+		"X-RAW":       "raw",
+		"X-DELETED":   "false",
+		"X-PRICE":     "10.50",
+		"X-TIME":      "2019-06-01T10:00:00Z",
+		"X-CONTEXT":   `{"actor":1}`,
+		"X-NIL":       "",
+	}, headers)
+
+	// permanent properties of the message, never retried
+	_, err = target.message(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.Equal(&TargetError{Action: ActionDeadLetter, Err: errors.New("kafka key not found: body:portal.id")}, err)
+
+	_, err = target.message(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"portal": {"id": 555}}`)})
+	ass.Equal(&TargetError{Action: ActionDeadLetter, Err: errors.New("kafka partition not found: header:X-PARTITION")}, err)
+
+	_, err = target.message(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"portal": {"id": 555}}`), Headers: amqp.Table{"X-PARTITION": "first"}})
+	targetErr, ok := err.(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDeadLetter, targetErr.Action)
+	ass.Contains(targetErr.Error(), "invalid kafka partition header:X-PARTITION")
+}
+
+func TestKafkaMurmur2Partitioner(t *testing.T) {
+	ass := assert.New(t)
+
+	// test cases of the Java client
+	for key, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		ass.Equal(expected, murmur2([]byte(key)), key)
+	}
+
+	// (murmur2(key) & 0x7fffffff) % partitions
+	partitioner := newMurmur2Partitioner("core-lo")
+	for key, expected := range map[string]int32{"21": 0, "foobar": 6, "abc": 7} {
+		partition, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, 10)
+		ass.NoError(err)
+		ass.Equal(expected, partition, key)
+	}
+}
+
+func TestKafkaAsyncTarget(t *testing.T) {
+	ass := assert.New(t)
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+	producer.ExpectInputAndSucceed()
+
+	target := newKafkaAsyncTarget(&KafkaTarget{Topic: "core-lo", cnf: &KafkaServiceConfig{}}, producer)
+	ass.NoError(target.start())
+
+	// callbacks of successes & errors are called concurrently
+	results := []chan error{make(chan error, 1), make(chan error, 1), make(chan error, 1)}
+	for i, result := range results[:2] {
+		result := result
+		target.handleAsync(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(fmt.Sprintf(`{"id": %d}`, i))}, func(err error) { result <- err })
+	}
+	ass.NoError(<-results[0])
+	ass.Equal(sarama.ErrNotLeaderForPartition, <-results[1])
+
+	// messages failing before being sent are reported too
+	target.target.cnf.Key = "body:missing"
+	target.handleAsync(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{}`)}, func(err error) { results[2] <- err })
+	ass.Equal(&TargetError{Action: ActionDeadLetter, Err: errors.New("kafka key not found: body:missing")}, <-results[2])
+
+	target.target.cnf.Key = ""
+	_, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 3}`)})
+	ass.NoError(err)
+
+	ass.NoError(target.terminate())
+}
+
+func TestKafkaTargetBatch(t *testing.T) {
+	ass := assert.New(t)
+	producer := mocks.NewSyncProducer(t, sarama.NewConfig())
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	defer producer.Close()
+
+	target := &KafkaTarget{Client: producer, Topic: "core-lo", cnf: &KafkaServiceConfig{Key: "body:portal.id"}}
+	_, err := target.handleBatch([]*amqp.Delivery{
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 1, "portal": {"id": 555}}`)},
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 2}`)},
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 3, "portal": {"id": 555}}`)},
+	})
+
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Len(batchErr.Errs, 1, "other members are sent")
+	ass.Equal(ActionDeadLetter, batchErr.Errs[1].(*TargetError).Action)
+}
+
+func TestKafkaStartupFailure(t *testing.T) {
+	_, err := NewKafkaTarget(
+		&AppConfig{Kafka: &map[string]KafkaConnectionOptions{"default": {Servers: []string{"127.0.0.1:1"}}}},
+		&ServiceConfig{Name: "core", Target: &TargetConfig{Kafka: &KafkaServiceConfig{Topic: "core-lo"}}},
+	)
+	assert.Error(t, err)
+
+	_, err = NewKafkaTarget(
+		&AppConfig{Kafka: &map[string]KafkaConnectionOptions{}},
+		&ServiceConfig{Name: "core", Target: &TargetConfig{Kafka: &KafkaServiceConfig{Connection: "write"}}},
+	)
+	assert.EqualError(t, err, "kafka connection not found: write")
+}
+
+func TestKafkaSecurityConfig(t *testing.T) {
+	ass := assert.New(t)
+	t.Setenv("KAFKA_PASSWORD", "pencil")
+
+	o := KafkaConnectionOptions{
+		Version: "2.1.0",
+		Tls:     &TlsConfig{ServerName: "kafka.example.com"},
+		Sasl:    &KafkaSaslConfig{Mechanism: "SCRAM-SHA-512", Username: "user", Password: &Secret{Env: "KAFKA_PASSWORD"}},
+	}
+	c, err := o.config(sarama.NewHashPartitioner)
+	ass.NoError(err)
+	ass.Equal(sarama.V2_1_0_0, c.Version)
+	ass.True(c.Net.TLS.Enable)
+	ass.Equal("kafka.example.com", c.Net.TLS.Config.ServerName)
+	ass.True(c.Net.SASL.Enable)
+	ass.Equal(sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), c.Net.SASL.Mechanism)
+	ass.Equal("pencil", c.Net.SASL.Password)
+
+	// full exchange with a SCRAM server
+	client := c.Net.SASL.SCRAMClientGeneratorFunc()
+	credentials, _ := scram.SHA512.NewClient("user", "pencil", "")
+	server, _ := scram.SHA512.NewServer(func(username string) (scram.StoredCredentials, error) {
+		return credentials.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096}), nil
+	})
+	conversation := server.NewConversation()
+
+	ass.NoError(client.Begin("user", "pencil", ""))
+	challenge := ""
+	for !client.Done() {
+		response, err := client.Step(challenge)
+		ass.NoError(err)
+		if client.Done() {
+			break
+		}
+
+		challenge, err = conversation.Step(response)
+		ass.NoError(err)
+	}
+	ass.True(conversation.Valid())
+
+	o.Sasl.Mechanism = "GSSAPI"
+	_, err = o.config(sarama.NewHashPartitioner)
+	ass.EqualError(err, "unsupported kafka sasl mechanism: GSSAPI")
+}
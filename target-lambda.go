@@ -3,46 +3,86 @@ package rabbitmq_consumer_bridge
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"github.com/tidwall/gjson"
 )
 
 type LambdaConfig struct {
-	AuthKey        string `yaml:"auth-key"`
-	AuthSecret     string `yaml:"auth-secret"`
-	Region         string `yaml:"region"`
-	Version        string `yaml:"version"`
-	InvocationType string `yaml:"invocation-type"`
+	AwsConfig      `yaml:",inline"`
+	Version        string      `yaml:"version"` // default qualifier of functions, ex: latest, 3, live
+	InvocationType string      `yaml:"invocation-type"`
+	Auth           *AuthConfig `yaml:"auth"`           // only bearer is supported, token is sent as `jwt` query of the event URL
+	Payload        string      `yaml:"payload"`        // envelope (default): HTTP-like event, raw: message body is the event
+	FunctionError  string      `yaml:"function-error"` // action on errors thrown by the function: retry (default), dead-letter, drop
 
 	invoker *lambda.Lambda
 }
 
+type LambdaRouteConfig struct {
+	Function  string `yaml:"function"`  // Default: name of service
+	Qualifier string `yaml:"qualifier"` // version or alias, default: lambda.version
+}
+
 func (o *LambdaConfig) OnAppStart(cnf *AppConfig) {
-	awsConfig := aws.NewConfig().
-		WithRegion(cnf.Lambda.Region).
-		WithCredentials(credentials.NewStaticCredentials(cnf.Lambda.AuthKey, cnf.Lambda.AuthSecret, ""))
-	sess, err := session.NewSession(awsConfig)
+	sess, err := cnf.Lambda.session()
 	if err != nil {
 		logrus.WithError(err).Panic("failed to create aws session")
 	}
 
-	cnf.Lambda.invoker = lambda.New(sess)
+	cnf.Lambda.invoker = lambda.New(sess, cnf.Lambda.config())
+
+	if nil == cnf.Lambda.Auth {
+		cnf.Lambda.Auth = legacyAuth("lambda")
+	}
 }
 
 type LambdaTarget struct {
 	cnf          *LambdaConfig
 	functionName string
+	routes       []RouteConfig
+	url          string
 }
 
-func NewLambdaTarget(functionName string, cnf *LambdaConfig) (Target, error) {
+func NewLambdaTarget(service *ServiceConfig, cnf *LambdaConfig) (Target, error) {
+	if nil == cnf {
+		return nil, errors.New("missing lambda configuration")
+	}
+
 	t := &LambdaTarget{
 		cnf:          cnf,
-		functionName: functionName,
+		functionName: service.Name,
+		routes:       service.Routes,
+		url:          "/consume",
+	}
+
+	switch cnf.Payload {
+	case "", "envelope", "raw":
+	default:
+		return nil, fmt.Errorf("unsupported lambda payload: %s", cnf.Payload)
+	}
+
+	switch cnf.FunctionError {
+	case "", ActionRetry, ActionDeadLetter, ActionDrop:
+	default:
+		return nil, fmt.Errorf("invalid action for lambda function errors: %s", cnf.FunctionError)
+	}
+
+	if nil != cnf.Auth {
+		if "bearer" != cnf.Auth.Type {
+			return nil, errors.New("unsupported lambda auth type: " + cnf.Auth.Type)
+		}
+
+		jwt, err := cnf.Auth.Token.value()
+		if nil != err {
+			return nil, err
+		}
+
+		t.url += "?jwt=" + url.QueryEscape(jwt)
 	}
 
 	return t, nil
@@ -53,34 +93,170 @@ func (t *LambdaTarget) start() error { return nil }
 func (t *LambdaTarget) terminate() error { return nil }
 
 func (t *LambdaTarget) handle(m *amqp.Delivery) ([]byte, error) {
-	payload, _ := json.Marshal(
-		map[string]interface{}{
-			"method":  "POST",
-			"url":     "/consume?jwt=" + RootJwt,
-			"headers": map[string]string{"Content-Type": "application/json"},
-			"body": map[string]interface{}{
-				"routingKey": m.RoutingKey,
-				"body":       m.Body,
-				"context":    m.Headers,
-			},
-		},
-	)
+	function, qualifier := t.function(m)
+	if "raw" == t.cnf.Payload {
+		return t.invoke(function, qualifier, rawEvent(m))
+	}
+
+	return t.invoke(function, qualifier, t.envelope(event(m)))
+}
+
+// Deliver messages of the same function in a single invocation, event body is a list of messages.
+func (t *LambdaTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	type group struct {
+		function  string
+		qualifier string
+		events    []interface{}
+		members   []int
+	}
+
+	groups := []*group{}
+	index := map[string]*group{}
+	for i, m := range ms {
+		function, qualifier := t.function(m)
+		g, ok := index[function+":"+qualifier]
+		if !ok {
+			g = &group{function: function, qualifier: qualifier}
+			index[function+":"+qualifier] = g
+			groups = append(groups, g)
+		}
+
+		if "raw" == t.cnf.Payload {
+			g.events = append(g.events, rawEvent(m))
+		} else {
+			g.events = append(g.events, event(m))
+		}
+
+		g.members = append(g.members, i)
+	}
+
+	errs := map[int]error{}
+	responses := [][]byte{}
+	for _, g := range groups {
+		var payload interface{} = g.events
+		if "raw" != t.cnf.Payload {
+			payload = t.envelope(g.events)
+		}
+
+		response, err := t.invoke(g.function, g.qualifier, payload)
+		if 1 == len(groups) {
+			return response, err
+		}
+
+		if nil != err {
+			for _, i := range g.members {
+				errs[i] = err
+			}
+
+			continue
+		}
+
+		responses = append(responses, response)
+	}
+
+	// only members of the failed functions are retried, responses of multiple functions can't be merged
+	return nil, &BatchError{Errs: errs, Responses: responses}
+}
+
+func (t *LambdaTarget) function(m *amqp.Delivery) (string, string) {
+	function, qualifier := t.functionName, t.cnf.Version
+	for _, route := range t.routes {
+		if route.Name == m.RoutingKey && nil != route.Lambda {
+			if "" != route.Lambda.Function {
+				function = route.Lambda.Function
+			}
+
+			if "" != route.Lambda.Qualifier {
+				qualifier = route.Lambda.Qualifier
+			}
+
+			break
+		}
+	}
+
+	if "latest" == qualifier {
+		qualifier = "$LATEST"
+	}
+
+	return function, qualifier
+}
+
+func event(m *amqp.Delivery) map[string]interface{} {
+	return map[string]interface{}{
+		"routingKey": m.RoutingKey,
+		"body":       m.Body,
+		"context":    m.Headers,
+	}
+}
+
+// Message body is the event, encoded as JSON string if it's not JSON.
+func rawEvent(m *amqp.Delivery) json.RawMessage {
+	if json.Valid(m.Body) {
+		return m.Body
+	}
+
+	raw, _ := json.Marshal(string(m.Body))
+
+	return raw
+}
+
+func (t *LambdaTarget) envelope(body interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"method":  "POST",
+		"url":     t.url,
+		"headers": map[string]string{"Content-Type": "application/json"},
+		"body":    body,
+	}
+}
+
+func (t *LambdaTarget) invoke(function string, qualifier string, body interface{}) ([]byte, error) {
+	payload, _ := json.Marshal(body)
 
 	input := &lambda.InvokeInput{
-		FunctionName:   &t.functionName,
+		FunctionName:   &function,
 		InvocationType: &t.cnf.InvocationType,
 		Payload:        payload,
 	}
 
-	_, err := t.cnf.invoker.Invoke(input)
-	if nil == err {
-		return nil, nil
+	if "" != qualifier {
+		input.Qualifier = &qualifier
 	}
 
-	logrus.
-		WithError(err).
-		WithField("component", "target-lambda").
-		Error("failed to invoke lambda function")
+	output, err := t.cnf.invoker.Invoke(input)
+	if nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-lambda").
+			WithField("function", function).
+			Error("failed to invoke lambda function")
+
+		return nil, errors.New("failed to invoke lambda function")
+	}
+
+	if nil != output.FunctionError {
+		err := fmt.Errorf(
+			"lambda function error %s: %s",
+			*output.FunctionError,
+			gjson.GetBytes(output.Payload, "errorMessage").String(),
+		)
+
+		logrus.
+			WithError(err).
+			WithField("component", "target-lambda").
+			WithField("function", function).
+			Error("lambda function failed")
+
+		if "" == t.cnf.FunctionError || ActionRetry == t.cnf.FunctionError {
+			return nil, err
+		}
+
+		return nil, &TargetError{Action: t.cnf.FunctionError, Err: err}
+	}
+
+	// JSON response is returned to the pipeline.
+	if json.Valid(output.Payload) && "null" != string(output.Payload) {
+		return output.Payload, nil
+	}
 
-	return nil, errors.New("failed to invoke lambda function")
+	return nil, nil
 }
@@ -0,0 +1,124 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLambdaTestServer(invocations chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		invocations <- r.URL.Path + "?" + r.URL.RawQuery + " " + string(payload)
+
+		if "/2015-03-31/functions/failing/invocations" == r.URL.Path {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+			_, _ = w.Write([]byte(`{"errorMessage": "boom", "errorType": "Error"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"status": "done"}`))
+	}))
+}
+
+func TestLambdaTarget(t *testing.T) {
+	ass := assert.New(t)
+	invocations := make(chan string, 1)
+	server := newLambdaTestServer(invocations)
+	defer server.Close()
+
+	cnf := &LambdaConfig{
+		AwsConfig:     AwsConfig{AuthKey: "key", AuthSecret: "secret", Region: "ap-southeast-2", Endpoint: server.URL},
+		Version:       "latest",
+		Payload:       "raw",
+		FunctionError: ActionDeadLetter,
+	}
+	cnf.OnAppStart(&AppConfig{Lambda: cnf})
+
+	target, err := NewLambdaTarget(&ServiceConfig{
+		Name: "lo-index",
+		Routes: []RouteConfig{
+			{Name: "lo.delete", Lambda: &LambdaRouteConfig{Function: "lo-delete", Qualifier: "live"}},
+			{Name: "lo.fail", Lambda: &LambdaRouteConfig{Function: "failing"}},
+		},
+	}, cnf)
+	ass.NoError(err)
+
+	response, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.NoError(err)
+	ass.Equal(`{"status": "done"}`, string(response))
+	ass.Equal(`/2015-03-31/functions/lo-index/invocations?Qualifier=%24LATEST {"id":1}`, <-invocations)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.delete", Body: []byte(`not json`)})
+	ass.NoError(err)
+	ass.Equal(`/2015-03-31/functions/lo-delete/invocations?Qualifier=live "not json"`, <-invocations)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.fail", Body: []byte(`{}`)})
+	<-invocations
+	targetErr, ok := err.(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDeadLetter, targetErr.Action)
+	ass.EqualError(targetErr.Err, "lambda function error Unhandled: boom")
+}
+
+func TestLambdaDefaultCredentialChain(t *testing.T) {
+	ass := assert.New(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDFROMENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+
+	authorization := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	cnf := &LambdaConfig{AwsConfig: AwsConfig{Region: "ap-southeast-2", Endpoint: server.URL}}
+	cnf.OnAppStart(&AppConfig{Lambda: cnf})
+	target, _ := NewLambdaTarget(&ServiceConfig{Name: "lo-index"}, cnf)
+
+	_, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.NoError(err)
+	ass.Contains(<-authorization, "Credential=AKIDFROMENV/")
+}
+
+func TestLambdaTargetBatch(t *testing.T) {
+	ass := assert.New(t)
+	invocations := make(chan string, 2)
+	server := newLambdaTestServer(invocations)
+	defer server.Close()
+
+	cnf := &LambdaConfig{
+		AwsConfig: AwsConfig{AuthKey: "key", AuthSecret: "secret", Region: "ap-southeast-2", Endpoint: server.URL},
+		Payload:   "raw",
+	}
+	cnf.OnAppStart(&AppConfig{Lambda: cnf})
+
+	target, err := NewLambdaTarget(&ServiceConfig{
+		Name:   "lo-index",
+		Routes: []RouteConfig{{Name: "lo.fail", Lambda: &LambdaRouteConfig{Function: "failing"}}},
+	}, cnf)
+	ass.NoError(err)
+
+	// only members of the failing function are reported, response of the other function is kept
+	_, err = target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)},
+		{RoutingKey: "lo.fail", Body: []byte(`{"id": 2}`)},
+		{RoutingKey: "lo.update", Body: []byte(`{"id": 3}`)},
+	})
+	ass.Equal(`/2015-03-31/functions/lo-index/invocations? [{"id":1},{"id":3}]`, <-invocations)
+	ass.Equal(`/2015-03-31/functions/failing/invocations? [{"id":2}]`, <-invocations)
+
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Equal(1, len(batchErr.Errs))
+	ass.Error(batchErr.Errs[1])
+	ass.Equal([][]byte{[]byte(`{"status": "done"}`)}, batchErr.Responses)
+}
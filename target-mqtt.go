@@ -0,0 +1,147 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+type MqttTargetConfig struct {
+	Url      string        `yaml:"url"`       // tcp://localhost:1883, ssl://localhost:8883, ws://localhost:8080/mqtt
+	ClientId string        `yaml:"client-id"` // prefix, a random suffix makes it unique per worker. Default: service name
+	Topic    string        `yaml:"topic"`     // placeholders: %routing-key%, %routing-key.N%, %service%, ex: devices/%routing-key.1%/notifications
+	Qos      byte          `yaml:"qos"`       // 0 (default): at most once, 1: at least once, 2: exactly once
+	Retained bool          `yaml:"retained"`  // broker keeps the last message of the topic for new subscribers
+	Timeout  time.Duration `yaml:"timeout"`   // of connecting & publishing. Default: 5s
+	Username string        `yaml:"username"`
+	Password *Secret       `yaml:"password"`
+	Tls      *TlsConfig    `yaml:"tls"`
+}
+
+type MqttTarget struct {
+	cnf      *MqttTargetConfig
+	service  string
+	encoding *EncodingConfig
+	client   mqtt.Client
+}
+
+func NewMqttTarget(service string, cnf *MqttTargetConfig, encoding *EncodingConfig) (Target, error) {
+	if nil == cnf || "" == cnf.Url || "" == cnf.Topic {
+		return nil, errors.New("missing mqtt url or topic")
+	}
+
+	if cnf.Qos > 2 {
+		return nil, fmt.Errorf("unsupported mqtt qos: %d", cnf.Qos)
+	}
+
+	if "" == cnf.ClientId {
+		cnf.ClientId = service
+	}
+
+	if 0 == cnf.Timeout {
+		cnf.Timeout = 5 * time.Second
+	}
+
+	return &MqttTarget{cnf: cnf, service: service, encoding: encoding}, nil
+}
+
+func (t *MqttTarget) start() error {
+	options, err := t.cnf.options()
+	if nil != err {
+		return err
+	}
+
+	t.client = mqtt.NewClient(options)
+	token := t.client.Connect()
+	if !token.WaitTimeout(t.cnf.Timeout) {
+		return fmt.Errorf("mqtt connection is not established in %s", t.cnf.Timeout)
+	}
+
+	return token.Error()
+}
+
+func (c *MqttTargetConfig) options() (*mqtt.ClientOptions, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); nil != err {
+		return nil, err
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(c.Url).
+		SetClientID(c.ClientId + "-" + hex.EncodeToString(suffix)).
+		SetConnectTimeout(c.Timeout).
+		SetWriteTimeout(c.Timeout).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			logrus.
+				WithError(err).
+				WithField("component", "target-mqtt").
+				WithField("url", c.Url).
+				Warning("connection lost, reconnecting")
+		})
+
+	if "" != c.Username {
+		password, err := c.Password.value()
+		if nil != err {
+			return nil, err
+		}
+
+		options.SetUsername(c.Username).SetPassword(password)
+	}
+
+	if nil != c.Tls {
+		tlsConfig, err := c.Tls.config()
+		if nil != err {
+			return nil, err
+		}
+
+		options.SetTLSConfig(tlsConfig)
+	}
+
+	return options, nil
+}
+
+func (t *MqttTarget) terminate() error {
+	if nil != t.client {
+		t.client.Disconnect(uint(t.cnf.Timeout / time.Millisecond))
+	}
+
+	return nil
+}
+
+// The source message is acked once the broker acknowledged the publish: PUBACK of QoS 1,
+// PUBCOMP of QoS 2. QoS 0 messages are acked once written to the connection.
+// MQTT 3.1.1 has no headers, subscribers must expect the configured encoding.
+func (t *MqttTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	body, _, err := t.encoding.encode(m)
+	if nil != err {
+		return nil, err
+	}
+
+	topic := render(t.cnf.Topic, t.service, m)
+	token := t.client.Publish(topic, t.cnf.Qos, t.cnf.Retained, body)
+
+	err = fmt.Errorf("mqtt publish is not acknowledged in %s", t.cnf.Timeout)
+	if token.WaitTimeout(t.cnf.Timeout) {
+		err = token.Error()
+	}
+
+	if nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-mqtt").
+			WithField("topic", topic).
+			WithField("msg.routingKey", m.RoutingKey).
+			Error("failed publishing")
+
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,173 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Broker acknowledging publishes of the MQTT 3.1.1 flows, enough to test the target without an MQTT server.
+type mqttBroker struct {
+	url      string
+	password string
+
+	mu        sync.Mutex
+	published []*packets.PublishPacket
+}
+
+func newMqttBroker(t *testing.T, password string) *mqttBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	broker := &mqttBroker{url: "tcp://" + l.Addr().String(), password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if nil != err {
+				return
+			}
+
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (b *mqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if nil != err {
+			return
+		}
+
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if b.password != string(p.Password) {
+				connack.ReturnCode = packets.ErrRefusedNotAuthorised
+			}
+			reply = connack
+
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.published = append(b.published, p)
+			b.mu.Unlock()
+
+			switch p.Qos {
+			case 1:
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				reply = puback
+
+			case 2:
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = p.MessageID
+				reply = pubrec
+			}
+
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = p.MessageID
+			reply = pubcomp
+
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+
+		case *packets.DisconnectPacket:
+			return
+		}
+
+		if nil != reply {
+			if err := reply.Write(conn); nil != err {
+				return
+			}
+		}
+	}
+}
+
+func (b *mqttBroker) messages() []*packets.PublishPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.published
+}
+
+func TestMqttTarget(t *testing.T) {
+	ass := assert.New(t)
+	broker := newMqttBroker(t, "secret")
+
+	t.Setenv("MQTT_PASSWORD", "secret")
+	for _, qos := range []byte{0, 1, 2} {
+		target, err := NewMqttTarget("notify", &MqttTargetConfig{
+			Url:      broker.url,
+			Topic:    "devices/%routing-key.1%/notifications",
+			Qos:      qos,
+			Retained: true,
+			Username: "device",
+			Password: &Secret{Env: "MQTT_PASSWORD"},
+		}, nil)
+		ass.NoError(err)
+		ass.NoError(target.start())
+
+		_, err = target.handle(&amqp.Delivery{RoutingKey: "device.d1.notify", Body: []byte{'0' + qos}})
+		ass.NoError(err)
+		ass.NoError(target.terminate())
+	}
+
+	messages := broker.messages()
+	ass.Len(messages, 3)
+	for i, msg := range messages {
+		ass.Equal("devices/d1/notifications", msg.TopicName)
+		ass.Equal(byte(i), msg.Qos)
+		ass.True(msg.Retain)
+		ass.Equal([]byte{'0' + byte(i)}, msg.Payload)
+	}
+}
+
+func TestMqttTargetEncoding(t *testing.T) {
+	ass := assert.New(t)
+	broker := newMqttBroker(t, "")
+
+	target, err := NewMqttTarget("notify", &MqttTargetConfig{Url: broker.url, Topic: "devices", Qos: 1}, &EncodingConfig{Compress: "gzip"})
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "device.d1.notify", Body: []byte(`{"id": 1}`), Headers: amqp.Table{}})
+	ass.NoError(err)
+
+	messages := broker.messages()
+	ass.Len(messages, 1)
+	reader, err := gzip.NewReader(bytes.NewReader(messages[0].Payload))
+	ass.NoError(err)
+	body, _ := ioutil.ReadAll(reader)
+	ass.Equal(`{"id": 1}`, string(body))
+}
+
+func TestMqttTargetBadCredentials(t *testing.T) {
+	ass := assert.New(t)
+	broker := newMqttBroker(t, "secret")
+
+	t.Setenv("MQTT_PASSWORD", "wrong")
+	target, err := NewMqttTarget("notify", &MqttTargetConfig{Url: broker.url, Topic: "devices", Username: "device", Password: &Secret{Env: "MQTT_PASSWORD"}}, nil)
+	ass.NoError(err)
+	ass.Error(target.start())
+
+	_, err = NewMqttTarget("notify", &MqttTargetConfig{Url: broker.url, Topic: "devices", Qos: 3}, nil)
+	ass.Error(err)
+}
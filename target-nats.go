@@ -0,0 +1,191 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+type NatsTargetConfig struct {
+	Url         string        `yaml:"url"`         // nats://localhost:4222, comma separated for a cluster
+	Subject     string        `yaml:"subject"`     // placeholders: %routing-key%, %routing-key.N%, %service%. Default: %routing-key%
+	JetStream   bool          `yaml:"jetstream"`   // wait for the stream to ack, deduplicated by X-UUID header (Nats-Msg-Id)
+	Timeout     time.Duration `yaml:"timeout"`     // of connecting & publish acks. Default: 5s
+	Credentials string        `yaml:"credentials"` // path to .creds file of the user
+	Username    string        `yaml:"username"`
+	Password    *Secret       `yaml:"password"`
+	Token       *Secret       `yaml:"token"`
+	Tls         *TlsConfig    `yaml:"tls"`
+}
+
+type NatsTarget struct {
+	cnf      *NatsTargetConfig
+	service  string
+	encoding *EncodingConfig
+	conn     *nats.Conn
+	js       jetstream.JetStream
+}
+
+func NewNatsTarget(service string, cnf *NatsTargetConfig, encoding *EncodingConfig) (Target, error) {
+	if nil == cnf || "" == cnf.Url {
+		return nil, errors.New("missing nats url")
+	}
+
+	if "" == cnf.Subject {
+		cnf.Subject = "%routing-key%"
+	}
+
+	if 0 == cnf.Timeout {
+		cnf.Timeout = 5 * time.Second
+	}
+
+	return &NatsTarget{cnf: cnf, service: service, encoding: encoding}, nil
+}
+
+func (t *NatsTarget) start() error {
+	options, err := t.cnf.options(t.service)
+	if nil != err {
+		return err
+	}
+
+	t.conn, err = nats.Connect(t.cnf.Url, options...)
+	if nil != err {
+		return err
+	}
+
+	if t.cnf.JetStream {
+		if t.js, err = jetstream.New(t.conn); nil != err {
+			t.conn.Close()
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *NatsTargetConfig) options(service string) ([]nats.Option, error) {
+	options := []nats.Option{nats.Name(service), nats.Timeout(c.Timeout)}
+
+	if "" != c.Credentials {
+		options = append(options, nats.UserCredentials(c.Credentials))
+	}
+
+	if "" != c.Username {
+		password, err := c.Password.value()
+		if nil != err {
+			return nil, err
+		}
+
+		options = append(options, nats.UserInfo(c.Username, password))
+	}
+
+	if nil != c.Token {
+		token, err := c.Token.value()
+		if nil != err {
+			return nil, err
+		}
+
+		options = append(options, nats.Token(token))
+	}
+
+	if nil != c.Tls {
+		tlsConfig, err := c.Tls.config()
+		if nil != err {
+			return nil, err
+		}
+
+		options = append(options, nats.Secure(tlsConfig))
+	}
+
+	return options, nil
+}
+
+func (t *NatsTarget) terminate() error {
+	if nil != t.conn {
+		return t.conn.Drain()
+	}
+
+	return nil
+}
+
+func (t *NatsTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	msg, err := t.message(m)
+	if nil != err {
+		return nil, err
+	}
+
+	if t.cnf.JetStream {
+		err = t.publishJetStream(msg, m)
+	} else if err = t.conn.PublishMsg(msg); nil == err {
+		// the source message is acked only after the server received the message
+		err = t.conn.FlushTimeout(t.cnf.Timeout)
+	}
+
+	if nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-nats").
+			WithField("subject", msg.Subject).
+			WithField("msg.routingKey", m.RoutingKey).
+			Error("failed publishing")
+
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *NatsTarget) publishJetStream(msg *nats.Msg, m *amqp.Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.cnf.Timeout)
+	defer cancel()
+
+	options := []jetstream.PublishOpt{}
+	if uuid := headerValue(m.Headers["X-UUID"]); 0 != len(uuid) {
+		options = append(options, jetstream.WithMsgID(string(uuid)))
+	}
+
+	ack, err := t.js.PublishMsg(ctx, msg, options...)
+	if nil != err {
+		return err
+	}
+
+	if ack.Duplicate {
+		logrus.
+			WithField("component", "target-nats").
+			WithField("stream", ack.Stream).
+			WithField("msg.routingKey", m.RoutingKey).
+			Debug("duplicate message")
+	}
+
+	return nil
+}
+
+func (t *NatsTarget) message(m *amqp.Delivery) (*nats.Msg, error) {
+	body, contentEncoding, err := t.encoding.encode(m)
+	if nil != err {
+		return nil, err
+	}
+
+	msg := nats.NewMsg(render(t.cnf.Subject, t.service, m))
+	msg.Data = body
+
+	for key, value := range m.Headers {
+		msg.Header.Set(key, string(headerValue(value)))
+	}
+
+	if "" != m.ContentType {
+		msg.Header.Set("Content-Type", m.ContentType)
+	}
+
+	if "" != contentEncoding {
+		msg.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	return msg, nil
+}
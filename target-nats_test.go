@@ -0,0 +1,100 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func natsServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	t.Cleanup(s.Shutdown)
+
+	return s
+}
+
+func TestNatsTarget(t *testing.T) {
+	ass := assert.New(t)
+	s := natsServer(t)
+
+	conn, err := nats.Connect(s.ClientURL())
+	ass.NoError(err)
+	defer conn.Close()
+
+	messages := make(chan *nats.Msg, 1)
+	_, err = conn.ChanSubscribe("edge.lo.update", messages)
+	ass.NoError(err)
+	ass.NoError(conn.Flush())
+
+	target, err := NewNatsTarget("lo-edge", &NatsTargetConfig{Url: s.ClientURL(), Subject: "edge.%routing-key%"}, nil)
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	_, err = target.handle(&amqp.Delivery{
+		RoutingKey:  "lo.update",
+		ContentType: "application/json",
+		Body:        []byte(`{"id": 1}`),
+		Headers:     amqp.Table{"X-UUID": "b5a1c5a0", "X-VERSION": int32(2)},
+	})
+	ass.NoError(err)
+
+	select {
+	case msg := <-messages:
+		ass.Equal(`{"id": 1}`, string(msg.Data))
+		ass.Equal("b5a1c5a0", msg.Header.Get("X-UUID"))
+		ass.Equal("2", msg.Header.Get("X-VERSION"))
+		ass.Equal("application/json", msg.Header.Get("Content-Type"))
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+}
+
+func TestNatsTargetJetStream(t *testing.T) {
+	ass := assert.New(t)
+	s := natsServer(t)
+
+	conn, err := nats.Connect(s.ClientURL())
+	ass.NoError(err)
+	defer conn.Close()
+
+	js, err := jetstream.New(conn)
+	ass.NoError(err)
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "LO", Subjects: []string{"lo.>"}})
+	ass.NoError(err)
+
+	target, err := NewNatsTarget("lo-edge", &NatsTargetConfig{Url: s.ClientURL(), JetStream: true}, nil)
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	// redelivered message is deduplicated by X-UUID, also when the header is not a string
+	for _, uuid := range []interface{}{"b5a1c5a0", "b5a1c5a0", []byte("c7d2e6b1"), []byte("c7d2e6b1")} {
+		_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`), Headers: amqp.Table{"X-UUID": uuid}})
+		ass.NoError(err)
+	}
+
+	info, err := stream.Info(context.Background())
+	ass.NoError(err)
+	ass.Equal(uint64(2), info.State.Msgs)
+
+	// no stream for the subject, message is retried
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "user.update", Body: []byte(`{"id": 1}`), Headers: amqp.Table{}})
+	ass.Error(err)
+}
@@ -0,0 +1,241 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Max length of a response line written by worker processes.
+const processMaxLineSize = 64 * 1024 * 1024
+
+// Response line written by worker processes to stdout, for each message read from stdin.
+type ProcessResponse struct {
+	Action string          `json:"action"` // ack (default), retry, dead-letter, drop
+	Error  string          `json:"error"`
+	Body   json.RawMessage `json:"body"` // returned to the pipeline
+}
+
+// Long-running child process, receiving messages as JSON lines on stdin and answering on stdout.
+type processWorker struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	logged  chan struct{} // stderr is read to the end
+	exited  chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+	handled int
+}
+
+func startProcessWorker(cnf *ProcessTargetConfig) (*processWorker, error) {
+	process := strings.Fields(cnf.Cmd)
+	cmd := exec.Command(process[0], process[1:]...)
+	cmd.Dir = cnf.Dir
+	cmd.Env = os.Environ()
+	for key, value := range cnf.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	processGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if nil != err {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if nil != err {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if nil != err {
+		return nil, err
+	}
+
+	if err := cmd.Start(); nil != err {
+		return nil, err
+	}
+
+	w := &processWorker{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan []byte),
+		logged: make(chan struct{}),
+		exited: make(chan struct{}),
+		quit:   make(chan struct{}),
+	}
+
+	go w.read(stdout)
+	go w.log(stderr)
+
+	return w, nil
+}
+
+func (w *processWorker) read(stdout io.Reader) {
+	defer close(w.exited)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), processMaxLineSize)
+	for scanner.Scan() {
+		line := append([]byte{}, scanner.Bytes()...)
+		select {
+		case w.lines <- line:
+		case <-w.quit:
+			// stopping, remaining output is discarded
+		}
+	}
+
+	// pipes must be read to the end before waiting, Wait closes them
+	<-w.logged
+	_ = w.cmd.Wait()
+}
+
+func (w *processWorker) log(stderr io.Reader) {
+	defer close(w.logged)
+
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), processMaxLineSize)
+	for scanner.Scan() {
+		logrus.
+			WithField("component", "target-process").
+			WithField("pid", w.cmd.Process.Pid).
+			Warningln(scanner.Text())
+	}
+}
+
+func (w *processWorker) call(m *amqp.Delivery, timeout time.Duration) ([]byte, error) {
+	request, err := json.Marshal(Payload{RoutingKey: m.RoutingKey, Body: string(m.Body), Context: m.Headers})
+	if nil != err {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// a process which doesn't read stdin blocks the write once the pipe is full
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.stdin.Write(append(request, '\n'))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if nil != err {
+			return nil, err
+		}
+
+	case <-w.exited:
+		return nil, fmt.Errorf("worker process exited: %s", w.cmd.ProcessState)
+
+	case <-timer.C:
+		return nil, errors.New("worker process timed out")
+	}
+
+	select {
+	case line := <-w.lines:
+		w.handled++
+
+		return parseProcessResponse(line)
+
+	case <-w.exited:
+		return nil, fmt.Errorf("worker process exited: %s", w.cmd.ProcessState)
+
+	case <-timer.C:
+		return nil, errors.New("worker process timed out")
+	}
+}
+
+// Close stdin so that the process can exit gracefully, kill it if it does not in time.
+func (w *processWorker) stop(timeout time.Duration) {
+	w.once.Do(func() { close(w.quit) })
+	_ = w.stdin.Close()
+
+	select {
+	case <-w.exited:
+	case <-time.After(timeout):
+		w.kill()
+	}
+}
+
+func (w *processWorker) kill() {
+	w.once.Do(func() { close(w.quit) })
+	_ = killProcessGroup(w.cmd)
+	<-w.exited
+}
+
+func parseProcessResponse(line []byte) ([]byte, error) {
+	response := ProcessResponse{}
+	if err := json.Unmarshal(line, &response); nil != err {
+		return nil, fmt.Errorf("invalid worker response: %s", err)
+	}
+
+	var body []byte
+	if 0 != len(response.Body) && "null" != string(response.Body) {
+		body = response.Body
+	}
+
+	switch response.Action {
+	case "", ActionAck:
+		return body, nil
+
+	case ActionRetry, ActionDeadLetter, ActionDrop:
+		return nil, &TargetError{Action: response.Action, Err: errors.New(response.Error)}
+
+	default:
+		return nil, fmt.Errorf("invalid worker response action: %s", response.Action)
+	}
+}
+
+// Message is handled by an idle process of the pool, started on demand.
+func (t *ProcessTarget) callWorker(m *amqp.Delivery) ([]byte, error) {
+	slot := <-t.idle
+	defer func() { t.idle <- slot }()
+
+	worker := t.workers[slot]
+	if nil == worker {
+		var err error
+		if worker, err = startProcessWorker(&t.cnf); nil != err {
+			return nil, err
+		}
+
+		t.workers[slot] = worker
+	}
+
+	response, err := worker.call(m, t.cnf.Timeout)
+	if nil != err {
+		// answered failures are handled by the service, ex: retried
+		if _, ok := err.(*TargetError); !ok {
+			logrus.
+				WithError(err).
+				WithField("component", "target-process").
+				WithField("msg.routingKey", m.RoutingKey).
+				WithField("cmd", t.cnf.Cmd).
+				Error("worker failed, restarting")
+
+			// protocol state is unknown after a failure, next message is handled by a new process
+			worker.kill()
+			t.workers[slot] = nil
+
+			return nil, err
+		}
+	}
+
+	if t.cnf.MaxMessages > 0 && worker.handled >= t.cnf.MaxMessages {
+		worker.stop(t.cnf.Timeout)
+		t.workers[slot] = nil
+	}
+
+	return response, err
+}
@@ -3,22 +3,53 @@ package rabbitmq_consumer_bridge
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
-	"github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
+	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
 )
 
 type ProcessTarget struct {
 	serviceName string
 	cnf         ProcessTargetConfig
+	workers     []*processWorker // pool of worker mode, a slot is used by one message at a time
+	idle        chan int         // slots of the pool which are not handling a message
 }
 
 type ProcessTargetConfig struct {
-	Cmd     string        `yaml:"cmd"`
-	Timeout time.Duration `yaml:"timeout"`
+	Cmd         string            `yaml:"cmd"`
+	Timeout     time.Duration     `yaml:"timeout"`      // per message
+	Mode        string            `yaml:"mode"`         // exec (default): new process per message, worker: long-running process per service worker
+	MaxMessages int               `yaml:"max-messages"` // worker mode: restart the process after N messages, 0 to disable
+	Pool        int               `yaml:"pool"`         // worker mode: processes per service worker, members of a batch are handled in parallel. Default: 1
+	Dir         string            `yaml:"dir"`          // working directory, default: current directory
+	Env         map[string]string `yaml:"env"`          // added to the inherited environment
+
+	// exec mode
+	Input      string         `yaml:"input"`       // args (default): routing key & body as last arguments, stdin: body on stdin & routing key as ROUTING_KEY
+	HeadersEnv bool           `yaml:"headers-env"` // headers as environment variables, ex: X-Request-Id -> HEADER_X_REQUEST_ID
+	Stderr     string         `yaml:"stderr"`      // fail (default): output on stderr fails the message, log: stderr is logged only
+	ExitCodes  map[int]string `yaml:"exit-codes"`  // exit code -> ack, retry, dead-letter, drop. Default: any code acks, unlisted non-zero codes retry once configured
+}
+
+func (c *ProcessTargetConfig) action(code int) string {
+	if action, ok := c.ExitCodes[code]; ok {
+		return action
+	}
+
+	// failures are reported on stderr unless exit codes are configured
+	if 0 == code || 0 == len(c.ExitCodes) {
+		return ActionAck
+	}
+
+	return ActionRetry
 }
 
 func NewProcessTarget(serviceName string, cnf ProcessTargetConfig) (Target, error) {
@@ -28,7 +59,53 @@ func NewProcessTarget(serviceName string, cnf ProcessTargetConfig) (Target, erro
 	}
 
 	if t.cnf.Timeout == 0 {
-		t.cnf.Timeout = 10*time.Second
+		t.cnf.Timeout = 10 * time.Second
+	}
+
+	if "" == t.cnf.Mode {
+		t.cnf.Mode = "exec"
+	}
+
+	if "" == strings.TrimSpace(t.cnf.Cmd) {
+		return nil, errors.New("missing process cmd")
+	}
+
+	switch t.cnf.Mode {
+	case "exec", "worker":
+	default:
+		return nil, fmt.Errorf("unsupported process mode: %s", t.cnf.Mode)
+	}
+
+	switch t.cnf.Input {
+	case "", "args", "stdin":
+	default:
+		return nil, fmt.Errorf("unsupported process input: %s", t.cnf.Input)
+	}
+
+	switch t.cnf.Stderr {
+	case "", "fail", "log":
+	default:
+		return nil, fmt.Errorf("unsupported process stderr: %s", t.cnf.Stderr)
+	}
+
+	for code, action := range t.cnf.ExitCodes {
+		switch action {
+		case ActionAck, ActionRetry, ActionDeadLetter, ActionDrop:
+		default:
+			return nil, fmt.Errorf("invalid action for exit code %d: %s", code, action)
+		}
+	}
+
+	if t.cnf.Pool < 1 {
+		t.cnf.Pool = 1
+	}
+
+	if "worker" == t.cnf.Mode {
+		t.workers = make([]*processWorker, t.cnf.Pool)
+		t.idle = make(chan int, t.cnf.Pool)
+		for slot := range t.workers {
+			t.idle <- slot
+		}
 	}
 
 	return t, nil
@@ -36,9 +113,64 @@ func NewProcessTarget(serviceName string, cnf ProcessTargetConfig) (Target, erro
 
 func (t *ProcessTarget) start() error { return nil }
 
-func (t *ProcessTarget) terminate() error { return nil }
+func (t *ProcessTarget) terminate() error {
+	for slot, worker := range t.workers {
+		if nil != worker {
+			worker.stop(t.cnf.Timeout)
+			t.workers[slot] = nil
+		}
+	}
+
+	return nil
+}
+
+// Members are spread over the pool of worker processes, failures are reported per member.
+func (t *ProcessTarget) handleBatch(ms []*amqp.Delivery) ([]byte, error) {
+	responses := make([][]byte, len(ms))
+	failures := make([]error, len(ms))
+
+	var wg sync.WaitGroup
+	for i, m := range ms {
+		if "worker" != t.cnf.Mode {
+			responses[i], failures[i] = t.handle(m)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, m *amqp.Delivery) {
+			defer wg.Done()
+
+			responses[i], failures[i] = t.handle(m)
+		}(i, m)
+	}
+
+	wg.Wait()
+
+	if 1 == len(ms) {
+		return responses[0], failures[0]
+	}
+
+	batchErr := &BatchError{Errs: map[int]error{}}
+	for i := range ms {
+		if nil != failures[i] {
+			batchErr.Errs[i] = failures[i]
+		} else if nil != responses[i] {
+			batchErr.Responses = append(batchErr.Responses, responses[i])
+		}
+	}
+
+	if 0 == len(batchErr.Errs) && 0 == len(batchErr.Responses) {
+		return nil, nil
+	}
+
+	return nil, batchErr
+}
 
 func (t *ProcessTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	if "worker" == t.cnf.Mode {
+		return t.callWorker(m)
+	}
+
 	var (
 		stdOut bytes.Buffer
 		stdErr bytes.Buffer
@@ -54,39 +186,116 @@ func (t *ProcessTarget) handle(m *amqp.Delivery) ([]byte, error) {
 	process := strings.Fields(t.cnf.Cmd)
 	name := process[0]
 	args := process[1:]
-	args = append(args, m.RoutingKey)
-	args = append(args, string(m.Body))
+	if "stdin" != t.cnf.Input {
+		args = append(args, m.RoutingKey)
+		args = append(args, string(m.Body))
+	}
 
 	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = t.cnf.Dir
+	cmd.Env = t.env(m)
 	cmd.Stdout = &stdOut
 	cmd.Stderr = &stdErr
+	if "stdin" == t.cnf.Input {
+		cmd.Stdin = bytes.NewReader(m.Body)
+	}
+
+	processGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = time.Second
 	err := cmd.Run()
 
-	if 0 != stdErr.Len() {
+	if ctx.Err() == context.DeadlineExceeded {
 		logrus.
+			WithError(ctx.Err()).
+			Errorln("target-process execution timed out")
+
+		return nil, ctx.Err()
+	}
+
+	if 0 != stdErr.Len() {
+		log := logrus.
 			WithError(err).
 			WithField("msg.routingKey", m.RoutingKey).
 			WithField("msg.body", string(m.Body)).
 			WithField("cmd", t.cnf.Cmd).
-			WithField("error", stdErr.String()).
-			Errorln("stderr")
+			WithField("error", stdErr.String())
+
+		if "log" != t.cnf.Stderr {
+			log.Errorln("stderr")
 
+			return nil, errors.New("failed to execute the process")
+		}
 
-		return nil, errors.New("failed to execute the process")
+		log.Warningln("stderr")
 	}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		logrus.
-			WithError(ctx.Err()).
-			Errorln("target-process execution timed out")
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if nil != err {
+		return nil, err
+	}
 
-		return nil, ctx.Err()
+	switch action := t.cnf.action(code); action {
+	case ActionAck:
+		if 0 != stdOut.Len() {
+			return stdOut.Bytes(), nil
+		}
+
+		return nil, nil
+
+	case ActionRetry:
+		return nil, fmt.Errorf("process exited with code %d", code)
+
+	default:
+		return nil, &TargetError{Action: action, Err: fmt.Errorf("process exited with code %d", code)}
+	}
+}
+
+// Environment of the command: inherited, configured & message headers if enabled.
+func (t *ProcessTarget) env(m *amqp.Delivery) []string {
+	env := os.Environ()
+	for key, value := range t.cnf.Env {
+		env = append(env, key+"="+value)
+	}
+
+	if "stdin" == t.cnf.Input {
+		env = append(env, "ROUTING_KEY="+m.RoutingKey)
 	}
 
-	output := string(stdOut.Bytes())
-	if len(output) > 0 {
-		return stdOut.Bytes(), nil
+	if t.cnf.HeadersEnv {
+		for key, value := range m.Headers {
+			env = append(env, processHeaderEnv(key)+"="+processHeaderValue(value))
+		}
 	}
 
-	return nil, nil
+	return env
+}
+
+// X-Request-Id -> HEADER_X_REQUEST_ID
+func processHeaderEnv(key string) string {
+	name := []byte("HEADER_" + strings.ToUpper(key))
+	for i, char := range name {
+		if !('A' <= char && char <= 'Z' || '0' <= char && char <= '9' || '_' == char) {
+			name[i] = '_'
+		}
+	}
+
+	return string(name)
+}
+
+func processHeaderValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case []byte:
+		return string(value)
+
+	default:
+		raw, _ := json.Marshal(value)
+
+		return string(raw)
+	}
 }
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package rabbitmq_consumer_bridge
+
+import "os/exec"
+
+func processGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,172 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+const processWorkerScript = `
+while IFS= read -r line; do
+  case "$line" in
+    *crash*) exit 1;;
+    *slow*)  sleep 5;;
+    *pause*) sleep 0.2; echo "{\"body\": {\"pid\": $$}}";;
+    *drop*)  echo '{"action": "drop", "error": "invalid"}';;
+    *)       echo "{\"body\": {\"pid\": $$}}";;
+  esac
+done
+`
+
+func newProcessWorkerTarget(t *testing.T, maxMessages int, pool int) Target {
+	script := filepath.Join(t.TempDir(), "worker.sh")
+	assert.NoError(t, ioutil.WriteFile(script, []byte(processWorkerScript), 0644))
+
+	target, err := NewProcessTarget("lo-index", ProcessTargetConfig{
+		Cmd:         "sh " + script,
+		Mode:        "worker",
+		Timeout:     500 * time.Millisecond,
+		MaxMessages: maxMessages,
+		Pool:        pool,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, target.start())
+
+	return target
+}
+
+func TestProcessWorkerRecycling(t *testing.T) {
+	ass := assert.New(t)
+	target := newProcessWorkerTarget(t, 2, 1)
+	defer target.terminate()
+
+	pids := []int64{}
+	for i := 0; i < 3; i++ {
+		response, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+		ass.NoError(err)
+		pids = append(pids, gjson.GetBytes(response, "pid").Int())
+	}
+
+	ass.Equal(pids[0], pids[1], "same process handles messages")
+	ass.NotEqual(pids[1], pids[2], "process is recycled after max-messages")
+}
+
+func TestProcessWorkerFailures(t *testing.T) {
+	ass := assert.New(t)
+	target := newProcessWorkerTarget(t, 0, 1)
+	defer target.terminate()
+
+	_, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`drop`)})
+	ass.Equal(&TargetError{Action: ActionDrop, Err: errors.New("invalid")}, err)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`crash`)})
+	ass.Error(err)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`slow`)})
+	ass.EqualError(err, "worker process timed out")
+
+	response, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.NoError(err)
+	ass.True(gjson.GetBytes(response, "pid").Exists(), "process is restarted")
+}
+
+func TestProcessWorkerPool(t *testing.T) {
+	ass := assert.New(t)
+	target := newProcessWorkerTarget(t, 0, 2)
+	defer target.terminate()
+
+	start := time.Now()
+	_, err := target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		{RoutingKey: "lo.update", Body: []byte(`pause`)},
+		{RoutingKey: "lo.update", Body: []byte(`drop`)},
+		{RoutingKey: "lo.update", Body: []byte(`pause`)},
+	})
+
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Equal(map[int]error{1: &TargetError{Action: ActionDrop, Err: errors.New("invalid")}}, batchErr.Errs)
+	ass.Len(batchErr.Responses, 2)
+	ass.NotEqual(gjson.GetBytes(batchErr.Responses[0], "pid").Int(), gjson.GetBytes(batchErr.Responses[1], "pid").Int(), "members are handled by different processes")
+	ass.Less(int64(time.Since(start)), int64(400*time.Millisecond), "members are handled in parallel")
+}
+
+func TestProcessWorkerStdinTimeout(t *testing.T) {
+	ass := assert.New(t)
+	script := filepath.Join(t.TempDir(), "deaf.sh")
+	ass.NoError(ioutil.WriteFile(script, []byte("sleep 5"), 0644))
+
+	target, err := NewProcessTarget("lo-index", ProcessTargetConfig{Cmd: "sh " + script, Mode: "worker", Timeout: 200 * time.Millisecond})
+	ass.NoError(err)
+	defer target.terminate()
+
+	// larger than the pipe buffer, the process never reads it
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: make([]byte, 1024*1024)})
+	ass.EqualError(err, "worker process timed out")
+}
+
+func TestProcessStdinAndEnv(t *testing.T) {
+	ass := assert.New(t)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "env.sh")
+	ass.NoError(ioutil.WriteFile(script, []byte("pwd; cat; echo; printenv; echo warning >&2"), 0644))
+
+	target, err := NewProcessTarget("lo-index", ProcessTargetConfig{
+		Cmd:        "sh " + script,
+		Input:      "stdin",
+		HeadersEnv: true,
+		Stderr:     "log",
+		Dir:        dir,
+		Env:        map[string]string{"APP_ENV": "test"},
+	})
+	ass.NoError(err)
+
+	output, err := target.handle(&amqp.Delivery{
+		RoutingKey: "lo.update",
+		Body:       []byte(`{"id": 1}`),
+		Headers:    amqp.Table{"X-Request-Id": "abc", "X-Context": amqp.Table{"actor": 1}},
+	})
+	ass.NoError(err)
+	ass.Contains(string(output), dir)
+	ass.Contains(string(output), `{"id": 1}`)
+	ass.Contains(string(output), "APP_ENV=test")
+	ass.Contains(string(output), "ROUTING_KEY=lo.update")
+	ass.Contains(string(output), "HEADER_X_REQUEST_ID=abc")
+	ass.Contains(string(output), `HEADER_X_CONTEXT={"actor":1}`)
+}
+
+func TestProcessExitCodes(t *testing.T) {
+	ass := assert.New(t)
+	script := filepath.Join(t.TempDir(), "exit.sh")
+	ass.NoError(ioutil.WriteFile(script, []byte("exit $1"), 0644))
+
+	target, err := NewProcessTarget("lo-index", ProcessTargetConfig{
+		Cmd:       "sh " + script,
+		ExitCodes: map[int]string{2: ActionDeadLetter, 3: ActionAck},
+	})
+	ass.NoError(err)
+
+	for routingKey, expected := range map[string]error{
+		"0": nil,
+		"1": errors.New("process exited with code 1"),
+		"2": &TargetError{Action: ActionDeadLetter, Err: errors.New("process exited with code 2")},
+		"3": nil,
+	} {
+		_, err := target.handle(&amqp.Delivery{RoutingKey: routingKey, Body: []byte(`{}`)})
+		ass.Equal(expected, err, "exit code %s", routingKey)
+	}
+
+	// without exit codes, only stderr fails the message
+	target, err = NewProcessTarget("lo-index", ProcessTargetConfig{Cmd: "sh " + script})
+	ass.NoError(err)
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "1", Body: []byte(`{}`)})
+	ass.NoError(err)
+
+	_, err = NewProcessTarget("lo-index", ProcessTargetConfig{Cmd: "true", ExitCodes: map[int]string{1: "ignore"}})
+	ass.Error(err)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package rabbitmq_consumer_bridge
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run the command in its own process group, so that its children are killed with it.
+func processGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
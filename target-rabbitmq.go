@@ -1,28 +1,86 @@
 package rabbitmq_consumer_bridge
 
 import (
-	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
 type RabbitMqTargetConfig struct {
-	URL      string `yaml:"url"`
-	Exchange string `yaml:"exchange"`
-	Kind     string `yaml:"kind"`
+	URL       string        `yaml:"url"`
+	Exchange  string        `yaml:"exchange"`
+	Kind      string        `yaml:"kind"`
+	Confirm   bool          `yaml:"confirm"`   // wait for the broker to confirm each message
+	Mandatory bool          `yaml:"mandatory"` // fail messages which are not routed to any queue, implies confirm
+	Timeout   time.Duration `yaml:"timeout"`   // of confirmation. Default: 5s
+
+	// target only
+	RoutingKey *RabbitMqRoutingKeyConfig `yaml:"routing-key"`
+	Properties *RabbitMqPropertiesConfig `yaml:"properties"`
+}
+
+// Routing key of the outgoing message: value at path, or original routing key -> regex replace -> template.
+type RabbitMqRoutingKeyConfig struct {
+	Path     string `yaml:"path"`     // gjson path of body, fallback to the original routing key if not found
+	Pattern  string `yaml:"pattern"`  // regex, ex: ^lo\.(.+)$
+	Replace  string `yaml:"replace"`  // ex: learning-object.$1
+	Template string `yaml:"template"` // placeholders: %routing-key% (rewritten), %routing-key.N%, %service%, ex: legacy.%routing-key%
+
+	pattern *regexp.Regexp
+}
+
+// AMQP properties of the outgoing message, properties of the original message are kept unless configured.
+type RabbitMqPropertiesConfig struct {
+	Persistent    bool          `yaml:"persistent"`     // delivery mode 2
+	Priority      uint8         `yaml:"priority"`       // 0-9
+	Expiration    time.Duration `yaml:"expiration"`     // per-message TTL
+	MessageId     string        `yaml:"message-id"`     // routing-key, header:NAME, body:GJSON_PATH
+	CorrelationId string        `yaml:"correlation-id"` // routing-key, header:NAME, body:GJSON_PATH
 }
 
 type RabbitMqTarget struct {
 	cnf        *RabbitMqTargetConfig
+	service    string
+	exchanges  map[string]string // routing key -> exchange
+	encoding   *EncodingConfig
 	Connection *amqp.Connection
 	Channel    *amqp.Channel
+	publisher  *publisher
 }
 
-func NewRabbitMqTarget(cnf *TargetConfig) (Target, error) {
+func NewRabbitMqTarget(service *ServiceConfig) (Target, error) {
+	cnf := service.Target
 	if "" == cnf.RabbitMq.Kind {
 		cnf.RabbitMq.Kind = "topic"
 	}
 
-	return &RabbitMqTarget{cnf: cnf.RabbitMq}, nil
+	if nil != cnf.RabbitMq.RoutingKey && "" != cnf.RabbitMq.RoutingKey.Pattern {
+		pattern, err := regexp.Compile(cnf.RabbitMq.RoutingKey.Pattern)
+		if nil != err {
+			return nil, fmt.Errorf("invalid routing key pattern: %s", err)
+		}
+
+		cnf.RabbitMq.RoutingKey.pattern = pattern
+	}
+
+	t := &RabbitMqTarget{
+		cnf:       cnf.RabbitMq,
+		service:   service.Name,
+		exchanges: map[string]string{},
+		encoding:  cnf.Encoding,
+	}
+
+	for _, route := range service.Routes {
+		if "" != route.Exchange {
+			t.exchanges[route.Name] = route.Exchange
+		}
+	}
+
+	return t, nil
 }
 
 func (t *RabbitMqTarget) start() error {
@@ -33,23 +91,39 @@ func (t *RabbitMqTarget) start() error {
 
 	t.Connection = conn
 	t.Channel = channel(conn, t.cnf.Kind, t.cnf.Exchange)
+	for _, exchange := range t.exchanges {
+		if err = t.Channel.ExchangeDeclare(exchange, t.cnf.Kind, false, false, false, false, nil); nil != err {
+			return err
+		}
+	}
 
-	return nil
+	t.publisher, err = newPublisher(t.Channel, t.cnf)
+
+	return err
 }
 
 func (t *RabbitMqTarget) handle(m *amqp.Delivery) ([]byte, error) {
-	msg := amqp.Publishing{
-		ContentType: m.ContentType,
-		Body:        m.Body,
-		Headers:     m.Headers,
+	body, contentEncoding, err := t.encoding.encode(m)
+	if nil != err {
+		return nil, err
 	}
 
-	err := t.Channel.Publish(t.cnf.Exchange, m.RoutingKey, false, false, msg)
-	if nil == err {
-		return nil, nil
+	msg := t.cnf.Properties.publishing(m)
+	msg.ContentEncoding = contentEncoding
+	msg.Body = body
+
+	routingKey := t.cnf.RoutingKey.render(t.service, m)
+	if err = t.publisher.send(t.exchange(m), routingKey, msg); nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-rabbitmq").
+			WithField("msg.routingKey", m.RoutingKey).
+			Error("failed pushing")
+
+		return nil, err
 	}
 
-	return nil, errors.New("failed pushing")
+	return nil, nil
 }
 
 func (t *RabbitMqTarget) terminate() error {
@@ -69,3 +143,76 @@ func (t *RabbitMqTarget) terminate() error {
 
 	return nil
 }
+
+func (t *RabbitMqTarget) exchange(m *amqp.Delivery) string {
+	if exchange, ok := t.exchanges[m.RoutingKey]; ok {
+		return exchange
+	}
+
+	return t.cnf.Exchange
+}
+
+func (c *RabbitMqRoutingKeyConfig) render(service string, m *amqp.Delivery) string {
+	if nil == c {
+		return m.RoutingKey
+	}
+
+	routingKey := m.RoutingKey
+	if "" != c.Path {
+		if value, ok := selectValue("body:"+c.Path, m); ok && 0 != len(value) {
+			routingKey = string(value)
+		}
+	}
+
+	if nil != c.pattern {
+		routingKey = c.pattern.ReplaceAllString(routingKey, c.Replace)
+	}
+
+	if "" == c.Template {
+		return routingKey
+	}
+
+	return render(c.Template, service, &amqp.Delivery{RoutingKey: routingKey})
+}
+
+func (c *RabbitMqPropertiesConfig) publishing(m *amqp.Delivery) amqp.Publishing {
+	msg := amqp.Publishing{
+		Headers:       m.Headers,
+		ContentType:   m.ContentType,
+		DeliveryMode:  m.DeliveryMode,
+		Priority:      m.Priority,
+		CorrelationId: m.CorrelationId,
+		ReplyTo:       m.ReplyTo,
+		Expiration:    m.Expiration,
+		MessageId:     m.MessageId,
+		Timestamp:     m.Timestamp,
+		Type:          m.Type,
+		AppId:         m.AppId,
+	}
+
+	if nil == c {
+		return msg
+	}
+
+	if c.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	if 0 != c.Priority {
+		msg.Priority = c.Priority
+	}
+
+	if 0 != c.Expiration {
+		msg.Expiration = strconv.FormatInt(c.Expiration.Milliseconds(), 10)
+	}
+
+	if value, ok := selectValue(c.MessageId, m); ok {
+		msg.MessageId = string(value)
+	}
+
+	if value, ok := selectValue(c.CorrelationId, m); ok {
+		msg.CorrelationId = string(value)
+	}
+
+	return msg
+}
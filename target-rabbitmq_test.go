@@ -0,0 +1,84 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRabbitMqRoutingKey(t *testing.T) {
+	ass := assert.New(t)
+	service := &ServiceConfig{
+		Name: "proxy",
+		Target: &TargetConfig{
+			Type: "rabbitmq",
+			RabbitMq: &RabbitMqTargetConfig{
+				Exchange: "events",
+				RoutingKey: &RabbitMqRoutingKeyConfig{
+					Path:     "event",
+					Pattern:  `^lo\.(.+)$`,
+					Replace:  "learning-object.$1",
+					Template: "legacy.%routing-key%",
+				},
+			},
+		},
+		Routes: []RouteConfig{{Name: "lo.update", Exchange: "learning-objects"}},
+	}
+
+	target, err := NewRabbitMqTarget(service)
+	ass.NoError(err)
+
+	rabbit := target.(*RabbitMqTarget)
+	m := &amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)}
+	ass.Equal("legacy.learning-object.update", rabbit.cnf.RoutingKey.render("proxy", m))
+	ass.Equal("learning-objects", rabbit.exchange(m))
+
+	m = &amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"event": "lo.delete"}`)}
+	ass.Equal("legacy.learning-object.delete", rabbit.cnf.RoutingKey.render("proxy", m))
+
+	m = &amqp.Delivery{RoutingKey: "user.create"}
+	ass.Equal("legacy.user.create", rabbit.cnf.RoutingKey.render("proxy", m))
+	ass.Equal("events", rabbit.exchange(m))
+
+	var none *RabbitMqRoutingKeyConfig
+	ass.Equal("user.create", none.render("proxy", m))
+
+	service.Target.RabbitMq.RoutingKey.Pattern = "("
+	_, err = NewRabbitMqTarget(service)
+	ass.Error(err)
+}
+
+func TestRabbitMqProperties(t *testing.T) {
+	ass := assert.New(t)
+	m := &amqp.Delivery{
+		RoutingKey:    "lo.update",
+		ContentType:   "application/json",
+		CorrelationId: "original",
+		Headers:       amqp.Table{"X-UUID": "b5a1c5a0"},
+		Body:          []byte(`{"id": 1}`),
+	}
+
+	var none *RabbitMqPropertiesConfig
+	msg := none.publishing(m)
+	ass.Equal("application/json", msg.ContentType)
+	ass.Equal("original", msg.CorrelationId)
+	ass.Equal(uint8(0), msg.DeliveryMode)
+
+	cnf := &RabbitMqPropertiesConfig{
+		Persistent:    true,
+		Priority:      5,
+		Expiration:    time.Minute,
+		MessageId:     "header:X-UUID",
+		CorrelationId: "body:id",
+	}
+
+	msg = cnf.publishing(m)
+	ass.Equal(amqp.Persistent, msg.DeliveryMode)
+	ass.Equal(uint8(5), msg.Priority)
+	ass.Equal("60000", msg.Expiration)
+	ass.Equal("b5a1c5a0", msg.MessageId)
+	ass.Equal("1", msg.CorrelationId)
+	ass.Equal(m.Headers, msg.Headers)
+}
@@ -0,0 +1,57 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStreamTarget(t *testing.T) {
+	ass := assert.New(t)
+	server := miniredis.RunT(t)
+
+	target, err := NewRedisTarget("lo-index", &RedisTargetConfig{
+		Url:    "redis://" + server.Addr(),
+		Stream: "events:%routing-key.0%",
+		MaxLen: 1,
+	})
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	for _, body := range []string{`{"id": 1}`, `{"id": 2}`} {
+		_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(body), Headers: amqp.Table{"X-VERSION": "v1"}})
+		ass.NoError(err)
+	}
+
+	entries, err := server.Stream("events:lo")
+	ass.NoError(err)
+	ass.Len(entries, 1, "stream is trimmed")
+	ass.Equal([]string{"routingKey", "lo.update", "body", `{"id": 2}`, "headers", `{"X-VERSION":"v1"}`}, entries[0].Values)
+}
+
+func TestRedisPublishTarget(t *testing.T) {
+	ass := assert.New(t)
+	server := miniredis.RunT(t)
+	subscriber := server.NewSubscriber()
+	defer subscriber.Close()
+	subscriber.Subscribe("lo-index.lo.update")
+	messages := make(chan miniredis.PubsubMessage, 1)
+	go func() { messages <- <-subscriber.Messages() }()
+
+	target, _ := NewRedisTarget("lo-index", &RedisTargetConfig{
+		Url:     "redis://" + server.Addr(),
+		Mode:    "publish",
+		Channel: "%service%.%routing-key%",
+	})
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	_, err := target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.NoError(err)
+
+	message := <-messages
+	ass.JSONEq(`{"routingKey": "lo.update", "body": "{\"id\": 1}", "context": null}`, message.Message)
+}
@@ -0,0 +1,81 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+type SnsTargetConfig struct {
+	AwsConfig      `yaml:",inline"`
+	TopicArn       string `yaml:"topic-arn"`
+	MessageGroupId string `yaml:"message-group-id"` // FIFO topic: gjson path of body, default: routing key
+}
+
+type SnsTarget struct {
+	cnf    *SnsTargetConfig
+	client *sns.SNS
+	fifo   bool
+}
+
+func NewSnsTarget(cnf *SnsTargetConfig) (Target, error) {
+	if nil == cnf || "" == cnf.TopicArn {
+		return nil, errors.New("missing sns topic-arn")
+	}
+
+	sess, err := cnf.session()
+	if nil != err {
+		return nil, err
+	}
+
+	return &SnsTarget{
+		cnf:    cnf,
+		client: sns.New(sess, cnf.config()),
+		fifo:   strings.HasSuffix(cnf.TopicArn, ".fifo"),
+	}, nil
+}
+
+func (t *SnsTarget) start() error { return nil }
+
+func (t *SnsTarget) terminate() error { return nil }
+
+func (t *SnsTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	input := &sns.PublishInput{
+		TopicArn:          aws.String(t.cnf.TopicArn),
+		Message:           aws.String(string(m.Body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{},
+	}
+
+	for key, attribute := range awsAttributes(m.Headers) {
+		input.MessageAttributes[key] = &sns.MessageAttributeValue{
+			DataType: aws.String(attribute.dataType),
+		}
+
+		if nil != attribute.binaryValue {
+			input.MessageAttributes[key].BinaryValue = attribute.binaryValue
+		} else {
+			input.MessageAttributes[key].StringValue = aws.String(attribute.stringValue)
+		}
+	}
+
+	if t.fifo {
+		input.MessageGroupId = aws.String(awsMessageGroupId(t.cnf.MessageGroupId, m))
+		input.MessageDeduplicationId = awsDeduplicationId(m)
+	}
+
+	if _, err := t.client.Publish(input); nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-sns").
+			WithField("topic", t.cnf.TopicArn).
+			Error("failed publishing message")
+
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,85 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnsTarget(t *testing.T) {
+	ass := assert.New(t)
+	requests := make(chan url.Values, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ass.NoError(r.ParseForm())
+		requests <- r.PostForm
+
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<PublishResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">
+			<PublishResult><MessageId>4d7c8fd1-0bde-4b1b-ae5d-f2a4e9c3a3d0</MessageId></PublishResult>
+			<ResponseMetadata><RequestId>f187a3c1-376f-11df-8963-01868b7c937a</RequestId></ResponseMetadata>
+		</PublishResponse>`))
+	}))
+	defer server.Close()
+
+	target, err := NewSnsTarget(&SnsTargetConfig{
+		AwsConfig:      AwsConfig{AuthKey: "key", AuthSecret: "secret", Region: "us-east-1", Endpoint: server.URL},
+		TopicArn:       "arn:aws:sns:us-east-1:123456789012:lo-index.fifo",
+		MessageGroupId: "portal_id",
+	})
+	ass.NoError(err)
+
+	_, err = target.handle(&amqp.Delivery{
+		RoutingKey: "lo.update",
+		Body:       []byte(`{"id": 1, "portal_id": 555}`),
+		Headers: amqp.Table{
+			"X-UUID":    "c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d",
+			"X-ATTEMPT": int32(2),
+		},
+	})
+	ass.NoError(err)
+
+	input := <-requests
+	ass.Equal("Publish", input.Get("Action"))
+	ass.Equal("arn:aws:sns:us-east-1:123456789012:lo-index.fifo", input.Get("TopicArn"))
+	ass.Equal(`{"id": 1, "portal_id": 555}`, input.Get("Message"))
+	ass.Equal("555", input.Get("MessageGroupId"))
+	ass.Equal("c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d", input.Get("MessageDeduplicationId"))
+
+	attributes := map[string][]string{}
+	for i := 1; "" != input.Get("MessageAttributes.entry."+strconv.Itoa(i)+".Name"); i++ {
+		entry := "MessageAttributes.entry." + strconv.Itoa(i)
+		attributes[input.Get(entry+".Name")] = []string{input.Get(entry + ".Value.DataType"), input.Get(entry + ".Value.StringValue")}
+	}
+	ass.Equal(map[string][]string{
+		"X-ATTEMPT": {"Number", "2"},
+		"X-UUID":    {"String", "c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d"},
+	}, attributes)
+}
+
+func TestSnsTargetFailure(t *testing.T) {
+	ass := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>NotFound</Code><Message>Topic does not exist</Message></Error></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	target, err := NewSnsTarget(&SnsTargetConfig{
+		AwsConfig: AwsConfig{AuthKey: "key", AuthSecret: "secret", Region: "us-east-1", Endpoint: server.URL},
+		TopicArn:  "arn:aws:sns:us-east-1:123456789012:lo-index",
+	})
+	ass.NoError(err)
+
+	_, err = target.handle(&amqp.Delivery{RoutingKey: "lo.update", Body: []byte(`{"id": 1}`)})
+	ass.Error(err)
+	ass.Contains(err.Error(), "Topic does not exist")
+
+	_, err = NewSnsTarget(&SnsTargetConfig{})
+	ass.Error(err)
+}
@@ -0,0 +1,112 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqlTarget(t *testing.T) {
+	ass := assert.New(t)
+	dsn := filepath.Join(t.TempDir(), "lo.db")
+	db, err := sql.Open("sqlite", dsn)
+	ass.NoError(err)
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE lo (id INTEGER PRIMARY KEY, title TEXT NOT NULL, price REAL, published BOOLEAN, data TEXT, version TEXT)`)
+	ass.NoError(err)
+
+	target, err := NewSqlTarget(&SqlTargetConfig{
+		Driver: "sqlite",
+		Dsn:    dsn,
+		Statements: []SqlStatementConfig{
+			{
+				RoutingKey: "lo.delete",
+				Query:      `DELETE FROM lo WHERE id = ?`,
+				Args:       []string{"body:id"},
+			},
+			{
+				RoutingKey: "lo.*",
+				Query:      `INSERT INTO lo (id, title, price, published, data, version) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET title = excluded.title, version = excluded.version`,
+				Args:       []string{"body:id", "body:title", "body:price", "body:published", "body", "header:X-VERSION"},
+			},
+		},
+	})
+	ass.NoError(err)
+	ass.NoError(target.start())
+	defer target.terminate()
+
+	lo := func(routingKey string, body string) *amqp.Delivery {
+		return &amqp.Delivery{RoutingKey: routingKey, Body: []byte(body), Headers: amqp.Table{"X-VERSION": int32(2)}}
+	}
+
+	_, err = target.handle(lo("lo.create", `{"id": 1, "title": "Go", "price": 9.5, "published": true}`))
+	ass.NoError(err)
+	_, err = target.handle(lo("lo.update", `{"id": 1, "title": "Go 2"}`))
+	ass.NoError(err)
+
+	var title, version string
+	var price float64
+	var published bool
+	ass.NoError(db.QueryRow(`SELECT title, price, published, version FROM lo WHERE id = 1`).Scan(&title, &price, &published, &version))
+	ass.Equal("Go 2", title)
+	ass.Equal(9.5, price)
+	ass.True(published)
+	ass.Equal("2", version)
+
+	// NOT NULL violation is dropped
+	_, err = target.handle(lo("lo.create", `{"id": 2}`))
+	targetErr, ok := err.(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDrop, targetErr.Action)
+
+	// only the violating member fails, the others are written
+	_, err = target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		lo("lo.create", `{"id": 3, "title": "Rust"}`),
+		lo("lo.create", `{"id": 4}`),
+		lo("lo.delete", `{"id": 1}`),
+		lo("user.create", `{"id": 5}`),
+	})
+	batchErr, ok := err.(*BatchError)
+	ass.True(ok)
+	ass.Equal(1, len(batchErr.Errs))
+	targetErr, ok = batchErr.Errs[1].(*TargetError)
+	ass.True(ok)
+	ass.Equal(ActionDrop, targetErr.Action)
+
+	ids := []int{}
+	rows, err := db.Query(`SELECT id FROM lo ORDER BY id`)
+	ass.NoError(err)
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	ass.Equal([]int{3}, ids)
+
+	// violations configured to be acked are not failures
+	target.(*SqlTarget).cnf.OnViolation = ActionAck
+	_, err = target.(BatchTarget).handleBatch([]*amqp.Delivery{
+		lo("lo.create", `{"id": 6, "title": "Zig"}`),
+		lo("lo.create", `{"id": 7}`),
+	})
+	ass.NoError(err)
+	_, err = target.handle(lo("lo.create", `{"id": 8}`))
+	ass.NoError(err)
+}
+
+func TestMatchRoutingKey(t *testing.T) {
+	ass := assert.New(t)
+	ass.True(matchRoutingKey("#", "lo.update"))
+	ass.True(matchRoutingKey("lo.#", "lo"))
+	ass.True(matchRoutingKey("lo.#", "lo.update.v1"))
+	ass.True(matchRoutingKey("*.update", "lo.update"))
+	ass.True(matchRoutingKey("#.update", "do.lo.update"))
+	ass.False(matchRoutingKey("lo.*", "lo"))
+	ass.False(matchRoutingKey("lo.*", "lo.update.v1"))
+	ass.False(matchRoutingKey("lo.update", "user.update"))
+}
@@ -0,0 +1,81 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+type SqsTargetConfig struct {
+	AwsConfig      `yaml:",inline"`
+	QueueUrl       string `yaml:"queue-url"`
+	MessageGroupId string `yaml:"message-group-id"` // FIFO queue: gjson path of body, default: routing key
+}
+
+type SqsTarget struct {
+	cnf    *SqsTargetConfig
+	client *sqs.SQS
+	fifo   bool
+}
+
+func NewSqsTarget(cnf *SqsTargetConfig) (Target, error) {
+	if nil == cnf || "" == cnf.QueueUrl {
+		return nil, errors.New("missing sqs queue-url")
+	}
+
+	sess, err := cnf.session()
+	if nil != err {
+		return nil, err
+	}
+
+	return &SqsTarget{
+		cnf:    cnf,
+		client: sqs.New(sess, cnf.config()),
+		fifo:   strings.HasSuffix(cnf.QueueUrl, ".fifo"),
+	}, nil
+}
+
+func (t *SqsTarget) start() error { return nil }
+
+func (t *SqsTarget) terminate() error { return nil }
+
+func (t *SqsTarget) handle(m *amqp.Delivery) ([]byte, error) {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(t.cnf.QueueUrl),
+		MessageBody:       aws.String(string(m.Body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+	}
+
+	for key, attribute := range awsAttributes(m.Headers) {
+		input.MessageAttributes[key] = &sqs.MessageAttributeValue{
+			DataType: aws.String(attribute.dataType),
+		}
+
+		if nil != attribute.binaryValue {
+			input.MessageAttributes[key].BinaryValue = attribute.binaryValue
+		} else {
+			input.MessageAttributes[key].StringValue = aws.String(attribute.stringValue)
+		}
+	}
+
+	if t.fifo {
+		input.MessageGroupId = aws.String(awsMessageGroupId(t.cnf.MessageGroupId, m))
+		input.MessageDeduplicationId = awsDeduplicationId(m)
+	}
+
+	if _, err := t.client.SendMessage(input); nil != err {
+		logrus.
+			WithError(err).
+			WithField("component", "target-sqs").
+			WithField("queue", t.cnf.QueueUrl).
+			Error("failed sending message")
+
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,77 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqsTarget(t *testing.T) {
+	ass := assert.New(t)
+	requests := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ass.Equal("AmazonSQS.SendMessage", r.Header.Get("X-Amz-Target"))
+
+		raw, _ := ioutil.ReadAll(r.Body)
+		input := map[string]interface{}{}
+		_ = json.Unmarshal(raw, &input)
+		requests <- input
+
+		checksum := md5.Sum([]byte(input["MessageBody"].(string)))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"MessageId":        "4d7c8fd1-0bde-4b1b-ae5d-f2a4e9c3a3d0",
+			"MD5OfMessageBody": hex.EncodeToString(checksum[:]),
+		})
+	}))
+	defer server.Close()
+
+	target, err := NewSqsTarget(&SqsTargetConfig{
+		AwsConfig:      AwsConfig{AuthKey: "key", AuthSecret: "secret", Region: "us-east-1", Endpoint: server.URL},
+		QueueUrl:       server.URL + "/123456789012/lo-index.fifo",
+		MessageGroupId: "portal_id",
+	})
+	ass.NoError(err)
+
+	_, err = target.handle(&amqp.Delivery{
+		RoutingKey: "lo.update",
+		Body:       []byte(`{"id": 1, "portal_id": 555}`),
+		Headers: amqp.Table{
+			"X-UUID":      "c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d",
+			"X-VERSION":   "v1",
+			"X-ATTEMPT":   int32(2),
+			"AWS.TraceId": "ignored",
+			"X-CONTEXT":   amqp.Table{"actor": 1},
+		},
+	})
+	ass.NoError(err)
+
+	input := <-requests
+	ass.Equal(`{"id": 1, "portal_id": 555}`, input["MessageBody"])
+	ass.Equal("555", input["MessageGroupId"])
+	ass.Equal("c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d", input["MessageDeduplicationId"])
+	ass.Equal(map[string]interface{}{
+		"X-ATTEMPT": map[string]interface{}{"DataType": "Number", "StringValue": "2"},
+		"X-CONTEXT": map[string]interface{}{"DataType": "String", "StringValue": `{"actor":1}`},
+		"X-UUID":    map[string]interface{}{"DataType": "String", "StringValue": "c2b5b5f0-4b0a-4b1e-9e0c-1f8b2c1b3c4d"},
+		"X-VERSION": map[string]interface{}{"DataType": "String", "StringValue": "v1"},
+	}, input["MessageAttributes"])
+}
+
+func TestAwsAttributesLimit(t *testing.T) {
+	headers := amqp.Table{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		headers[key] = key
+	}
+
+	attributes := awsAttributes(headers)
+	assert.Len(t, attributes, awsMaxMessageAttributes)
+	assert.NotContains(t, attributes, "k")
+}
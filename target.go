@@ -2,31 +2,78 @@ package rabbitmq_consumer_bridge
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/streadway/amqp"
 )
 
+// Actions applied on a message after the target failed handling it.
+const (
+	ActionAck        = "ack"
+	ActionRetry      = "retry"
+	ActionDeadLetter = "dead-letter"
+	ActionDrop       = "drop"
+)
+
 type Target interface {
 	handle(m *amqp.Delivery) ([]byte, error)
 	start() error
 	terminate() error
 }
 
+// Target reporting the result of each message later, so that many messages are in flight.
+type AsyncTarget interface {
+	handleAsync(m *amqp.Delivery, done func(err error))
+}
+
 func NewTarget(service *ServiceConfig) (Target, error) {
+	target, err := newTarget(service)
+	if nil != err || nil == service.Target || nil == service.Target.Encode {
+		return target, err
+	}
+
+	serializer, err := NewSerializer(service.Target.Encode, app.config.HttpClient.Get())
+	if nil != err {
+		return nil, err
+	}
+
+	return &serializedTarget{Target: target, serializer: serializer}, nil
+}
+
+func newTarget(service *ServiceConfig) (Target, error) {
 	if nil == service.Target {
 		return NewHttpTarget(service, app.config.HttpClient.Get())
 	}
 
+	if nil != service.Target.Encoding && !encodingTargets[service.Target.Type] {
+		return nil, errors.New(fmt.Sprintf("encoding is not supported by %s target", service.Target.Type))
+	}
+
 	switch service.Target.Type {
 	case "rabbitmq":
-		return NewRabbitMqTarget(service.Target)
+		return NewRabbitMqTarget(service)
 
 	case "http":
 		return NewHttpTarget(service, app.config.HttpClient.Get())
 
+	case "grpc":
+		return NewGrpcTarget(service.Target.Grpc)
+
+	case "redis":
+		return NewRedisTarget(service.Name, service.Target.Redis)
+
+	case "sqs":
+		return NewSqsTarget(service.Target.Sqs)
+
+	case "sns":
+		return NewSnsTarget(service.Target.Sns)
+
+	case "file":
+		return NewFileTarget(service.Name, service.Target.File)
+
 	case "lambda":
-		return NewLambdaTarget(service.Name, app.config.Lambda)
+		return NewLambdaTarget(service, app.config.Lambda)
 
 	case "kafka":
 		return NewKafkaTarget(app.config, service)
@@ -34,7 +81,30 @@ func NewTarget(service *ServiceConfig) (Target, error) {
 	case "process":
 		return NewProcessTarget(service.Name, service.Target.Process)
 
+	case "sql":
+		return NewSqlTarget(service.Target.Sql)
+
+	case "elasticsearch":
+		return NewElasticsearchTarget(service.Name, service.Target.Elasticsearch, app.config.HttpClient.Get())
+
+	case "nats":
+		return NewNatsTarget(service.Name, service.Target.Nats, service.Target.Encoding)
+
+	case "mqtt":
+		return NewMqttTarget(service.Name, service.Target.Mqtt, service.Target.Encoding)
+
 	default:
 		return nil, errors.New(fmt.Sprintf("unsupported target: %s", service.Target.Type))
 	}
 }
+
+// Error returned by targets which know how the failed message should be handled.
+type TargetError struct {
+	Action     string        // retry, dead-letter, drop
+	RetryAfter time.Duration // wait before retrying, instead of the configured retry-intervals
+	Err        error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Action, e.Err)
+}
@@ -0,0 +1,46 @@
+package rabbitmq_consumer_bridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+type TlsConfig struct {
+	CaFile             string `yaml:"ca-file"`   // Default: system CA pool
+	CertFile           string `yaml:"cert-file"` // client certificate
+	KeyFile            string `yaml:"key-file"`
+	ServerName         string `yaml:"server-name"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+}
+
+func (c *TlsConfig) config() (*tls.Config, error) {
+	cnf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if "" != c.CaFile {
+		ca, err := ioutil.ReadFile(c.CaFile)
+		if nil != err {
+			return nil, err
+		}
+
+		cnf.RootCAs = x509.NewCertPool()
+		if !cnf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CaFile)
+		}
+	}
+
+	if "" != c.CertFile {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if nil != err {
+			return nil, err
+		}
+
+		cnf.Certificates = []tls.Certificate{cert}
+	}
+
+	return cnf, nil
+}
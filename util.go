@@ -1,6 +1,10 @@
 package rabbitmq_consumer_bridge
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
@@ -9,6 +13,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"github.com/tidwall/gjson"
 )
 
 func Env(name string, defaultValue string) string {
@@ -67,7 +72,7 @@ func channel(con *amqp.Connection, kind string, exchangeName string) *amqp.Chann
 	return ch
 }
 
-func stream(ch *amqp.Channel, exchange string, queue string, routingKeys []string) <-chan amqp.Delivery {
+func stream(ch *amqp.Channel, exchange string, queue string, routingKeys []string, prefetch int) <-chan amqp.Delivery {
 	defer func() {
 		logrus.
 			WithField("exchange", exchange).
@@ -91,7 +96,7 @@ func stream(ch *amqp.Channel, exchange string, queue string, routingKeys []strin
 		ch.QueueBind(queue, routingKey, exchange, true, nil)
 	}
 
-	err = ch.Qos(1, 0, false)
+	err = ch.Qos(prefetch, 0, false)
 	if nil != err {
 		logrus.
 			WithError(err).
@@ -108,29 +113,31 @@ func stream(ch *amqp.Channel, exchange string, queue string, routingKeys []strin
 	return messages
 }
 
-func push(queue string, service string, m *amqp.Delivery) bool {
+func push(t *HttpTarget, m *amqp.Delivery) ([]byte, error) {
+	queue := t.queue
+	service := t.service
 	start := time.Now()
-	result := func() bool {
+	response, err := func() ([]byte, error) {
 		switch service {
 		case "consumer":
-			return app.pushDynamic(service, m)
+			return nil, pushDynamic(service, m)
 
 		case "lazy":
 			keys := strings.Split(m.RoutingKey, ".")
 			serviceName := keys[1] // do.SERVICE_NAME.# -> SERVICE_NAME
 
 			if serviceName == "consumer" {
-				return app.pushDynamic(service, m)
+				return nil, pushDynamic(service, m)
 			}
 
-			return app.push(serviceName, m)
+			return t.push(serviceName, m)
 
 		default:
-			return app.push(service, m)
+			return t.push(service, m)
 		}
 	}()
 
-	if result {
+	if nil == err {
 		promDurationHistogram.
 			WithLabelValues(queue, service, m.RoutingKey).
 			Observe(time.Since(start).Seconds())
@@ -145,11 +152,17 @@ func push(queue string, service string, m *amqp.Delivery) bool {
 			WithField("message.routingKey", m.RoutingKey).
 			WithField("message.body", string(m.Body)).
 			Info("executing microservice")
+	}
+
+	return response, err
+}
 
-		return true
+func pushDynamic(service string, m *amqp.Delivery) error {
+	if !app.pushDynamic(service, m) {
+		return errors.New("failed to push")
 	}
 
-	return false
+	return nil
 }
 
 func convert(m *amqp.Delivery) amqp.Publishing {
@@ -199,6 +212,52 @@ func hasElement(s interface{}, elem interface{}) bool {
 	return false
 }
 
+// Replace placeholders of the template:
+//   - %service%: name of the service
+//   - %routing-key%: routing key of the message
+//   - %routing-key.N%: Nth segment of the routing key, ex: %routing-key.0% of `lo.update` is `lo`
+func render(template string, service string, m *amqp.Delivery) string {
+	if !strings.Contains(template, "%") {
+		return template
+	}
+
+	template = strings.Replace(template, "%service%", service, -1)
+	template = strings.Replace(template, "%routing-key%", m.RoutingKey, -1)
+
+	for i, segment := range strings.Split(m.RoutingKey, ".") {
+		template = strings.Replace(template, "%routing-key."+strconv.Itoa(i)+"%", segment, -1)
+	}
+
+	return template
+}
+
+// Match routing key with a binding key of topic exchanges: * matches one word, # zero or more words.
+func matchRoutingKey(bindingKey string, routingKey string) bool {
+	return matchWords(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern []string, words []string) bool {
+	if 0 == len(pattern) {
+		return 0 == len(words)
+	}
+
+	if "#" == pattern[0] {
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if 0 == len(words) || ("*" != pattern[0] && pattern[0] != words[0]) {
+		return false
+	}
+
+	return matchWords(pattern[1:], words[1:])
+}
+
 func getPath(name string, env string, pattern string) string {
 	pattern = strings.Replace(pattern, "SERVICE", name, -1)
 	pattern = strings.Replace(pattern, "ENVIRONMENT", env, -1)
@@ -233,6 +292,42 @@ NEXT:
 				uuid = m.Headers["X-UUID"].(string)
 			}
 
+			// compressed body can't be handled by the target, and won't be decoded on redelivery either
+			if err := decode(&m); nil != err {
+				logrus.
+					WithError(err).
+					WithField("message.routingKey", m.RoutingKey).
+					WithField("message.contentEncoding", m.ContentEncoding).
+					Error("failed to decode message body, rejecting")
+
+				if nil != service && nil != service.DeadLetter {
+					service.DeadLetter.deliver(service, &m)
+				}
+
+				nack(uuid, m, false)
+				continue NEXT
+			}
+
+			if nil != service && nil != service.decoder {
+				body, err := service.decoder.decode(m.Body)
+				if nil != err {
+					logrus.
+						WithError(err).
+						WithField("service", service.Name).
+						WithField("message.routingKey", m.RoutingKey).
+						Error("failed to deserialize message body, rejecting")
+
+					if nil != service.DeadLetter {
+						service.DeadLetter.deliver(service, &m)
+					}
+
+					nack(uuid, m, false)
+					continue NEXT
+				}
+
+				m.Body = body
+			}
+
 			// Ignore if message doesn't pass the condition
 			if nil != service {
 				for _, route := range service.Routes {
@@ -273,3 +368,65 @@ func part(m *amqp.Delivery, path string) []byte {
 
 	return []byte("")
 }
+
+// Resolve value of message by selector: routing-key, header:NAME or body:GJSON_PATH.
+func selectValue(selector string, m *amqp.Delivery) ([]byte, bool) {
+	switch {
+	case "routing-key" == selector:
+		return []byte(m.RoutingKey), true
+
+	case strings.HasPrefix(selector, "header:"):
+		value, ok := m.Headers[strings.TrimPrefix(selector, "header:")]
+		if !ok || nil == value {
+			return nil, false
+		}
+
+		return headerValue(value), true
+
+	case strings.HasPrefix(selector, "body:"):
+		result := gjson.GetBytes(m.Body, strings.TrimPrefix(selector, "body:"))
+		if !result.Exists() {
+			return nil, false
+		}
+
+		if gjson.String == result.Type {
+			return []byte(result.Str), true
+		}
+
+		return []byte(result.Raw), true
+
+	default:
+		return nil, false
+	}
+}
+
+// Convert any value of AMQP table to bytes, nested tables & arrays are JSON encoded.
+func headerValue(value interface{}) []byte {
+	switch value := value.(type) {
+	case nil:
+		return nil
+
+	case []byte:
+		return value
+
+	case string:
+		return []byte(value)
+
+	case bool:
+		return []byte(strconv.FormatBool(value))
+
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64, float32, float64:
+		return []byte(fmt.Sprint(value))
+
+	case time.Time:
+		return []byte(value.UTC().Format(time.RFC3339))
+
+	case amqp.Decimal:
+		return []byte(strconv.FormatFloat(float64(value.Value)/math.Pow10(int(value.Scale)), 'f', int(value.Scale), 64))
+
+	default:
+		raw, _ := json.Marshal(value)
+
+		return raw
+	}
+}